@@ -0,0 +1,135 @@
+// Package backend abstracts where dank stores files. Seaweed is the default,
+// S3 stores files in an S3 compatible service and Local stores files on disk
+// so dank can be run without seaweed, like in development and CI.
+//
+// Every backend uses fids in seaweed's volume,keycookie format, wrapped in a
+// seaweed.AssignResult, so signatures, private files and derived filenames
+// work the same regardless of the backend
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"github.com/levenlabs/dank/config"
+	"github.com/levenlabs/dank/seaweed"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Backend stores and serves files. Implementations must be safe for
+// concurrent use
+type Backend interface {
+	// Assign reserves count files that can be uploaded to. replication and
+	// ttl are in seaweed's formats and may be ignored if the backend doesn't
+	// support them
+	Assign(replication, ttl string, count int) ([]*seaweed.AssignResult, error)
+
+	// Upload stores body as a file that was returned from Assign. urlParams
+	// can contain a ttl and a ts, the unix time the file was last modified
+	Upload(r *seaweed.AssignResult, body io.Reader, ct string, urlParams map[string]string) error
+
+	// Put is like Upload but for a filename that wasn't returned from
+	// Assign, like one from seaweed.DerivedFilename
+	Put(filename string, body io.Reader, ct string, urlParams map[string]string) error
+
+	// Get returns the contents of the file, which must be closed if it's not
+	// nil, and its headers. headers are request headers, like Range, that
+	// the backend may use. The contents are nil if the file wasn't modified
+	// since If-Modified-Since and the returned headers include Content-Range
+	// if only part of it was returned. If the backend tracks when the file
+	// expires, the returned headers include it as Expires
+	Get(filename string, headers, urlParams map[string]string) (io.ReadCloser, *http.Header, error)
+
+	// Exists returns whether the file exists
+	Exists(filename string) (bool, error)
+
+	// Lookup returns a url that the file can be downloaded from directly, for
+	// use with X-Upstream-Redirect
+	Lookup(filename string, urlParams map[string]string) (string, error)
+
+	// Delete removes the file
+	Delete(filename string) error
+
+	// Ready returns an error if files can't currently be stored or served
+	Ready() error
+}
+
+// New returns the Backend chosen by the Backend field of cfg
+func New(cfg *config.Config) (Backend, error) {
+	switch cfg.Backend {
+	case "seaweed":
+		return Seaweed(seaweed.NewClient(cfg)), nil
+	case "local":
+		l, err := NewLocal(cfg.BackendDir)
+		if err != nil {
+			return nil, fmt.Errorf("error opening backend dir %s: %s", cfg.BackendDir, err)
+		}
+		return l, nil
+	case "s3":
+		s, err := NewS3(cfg.S3Endpoint, cfg.S3Bucket, cfg.S3Region,
+			cfg.S3AccessKey, cfg.S3SecretKey, cfg.S3RedirectTTL)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("invalid backend %q", cfg.Backend)
+	}
+}
+
+// TTL returns a ttl, in seaweed's format, that expires a file derived from one
+// whose headers from Get are h no earlier than the original. An empty string
+// is returned if the original doesn't expire
+func TTL(h *http.Header) string {
+	if h == nil {
+		return ""
+	}
+	t, err := http.ParseTime(h.Get("Expires"))
+	if err != nil {
+		return ""
+	}
+	return TTLUntil(t)
+}
+
+// TTLUntil returns a ttl, in seaweed's format, that expires a file no earlier
+// than t
+func TTLUntil(t time.Time) string {
+	m := (t.Sub(time.Now()) + time.Minute - 1) / time.Minute
+	if m < 1 {
+		m = 1
+	}
+	return fmt.Sprintf("%dm", m)
+}
+
+// ParseTTL parses a ttl in seaweed's format, like 3m or 4d. The units are m,
+// h, d, w, M (30 days) and y (365 days)
+func ParseTTL(ttl string) (time.Duration, error) {
+	if len(ttl) < 2 {
+		return 0, errors.New("invalid ttl")
+	}
+	n, err := strconv.Atoi(ttl[:len(ttl)-1])
+	if err != nil || n < 1 {
+		return 0, errors.New("invalid ttl")
+	}
+	day := 24 * time.Hour
+	var unit time.Duration
+	switch ttl[len(ttl)-1] {
+	case 'm':
+		unit = time.Minute
+	case 'h':
+		unit = time.Hour
+	case 'd':
+		unit = day
+	case 'w':
+		unit = 7 * day
+	case 'M':
+		unit = 30 * day
+	case 'y':
+		unit = 365 * day
+	default:
+		return 0, errors.New("invalid ttl")
+	}
+	return time.Duration(n) * unit, nil
+}
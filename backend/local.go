@@ -0,0 +1,247 @@
+package backend
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	dhttp "github.com/levenlabs/dank/http"
+	"github.com/levenlabs/dank/seaweed"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// localVolume is the volume id put in the fids made by newFIDs
+const localVolume = 1
+
+var fidRegex = regexp.MustCompile(`^[0-9]+,[0-9a-fA-F]+(_[0-9]+)?$`)
+
+// localMeta is stored next to each file with what seaweed would've stored
+// about it
+type localMeta struct {
+	ContentType  string    `json:"contentType"`
+	LastModified time.Time `json:"lastModified"`
+	Expires      time.Time `json:"expires,omitempty"`
+}
+
+// Local is a Backend that stores files in a directory. Each file is stored
+// under its fid along with a .json file holding its content type, last
+// modified time and expiration
+type Local struct {
+	dir string
+}
+
+// NewLocal returns a Local storing files in dir, which is created if it
+// doesn't exist
+func NewLocal(dir string) (*Local, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Local{dir: dir}, nil
+}
+
+// path returns the path the file with the fid is stored at, or an error if
+// the fid isn't in the expected format, which also keeps it from escaping dir
+func (l *Local) path(fid string) (string, error) {
+	if !fidRegex.MatchString(fid) {
+		return "", errors.New("invalid fid")
+	}
+	return filepath.Join(l.dir, fid), nil
+}
+
+// Assign returns count new fids. The replication is ignored
+func (l *Local) Assign(replication, ttl string, count int) ([]*seaweed.AssignResult, error) {
+	return newFIDs(count)
+}
+
+// newFIDs returns count random fids in seaweed's format for backends that
+// don't have their own. Like seaweed, after the first they have _1, _2, etc
+// appended
+func newFIDs(count int) ([]*seaweed.AssignResult, error) {
+	if count < 1 {
+		count = 1
+	}
+	var b [12]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return nil, err
+	}
+	// the high bit is left unset like seaweed so it doesn't collide with
+	// derived files
+	key := binary.BigEndian.Uint64(b[:8]) &^ (1 << 63)
+	cookie := binary.BigEndian.Uint32(b[8:])
+	fid := seaweed.FormatFID(localVolume, key, cookie)
+	ars := make([]*seaweed.AssignResult, count)
+	for i := range ars {
+		f := fid
+		if i > 0 {
+			f += "_" + strconv.Itoa(i)
+		}
+		ars[i] = seaweed.NewRawAssignResult("", f)
+	}
+	return ars, nil
+}
+
+// Upload writes body to the file. The ttl and ts in urlParams are used the
+// same way seaweed uses them
+func (l *Local) Upload(r *seaweed.AssignResult, body io.Reader, ct string, urlParams map[string]string) error {
+	path, err := l.path(r.FID())
+	if err != nil {
+		return dhttp.NewError(http.StatusBadRequest,
+			"invalid filename sent: %s", r.Filename())
+	}
+	m := &localMeta{
+		ContentType:  ct,
+		LastModified: time.Now().UTC(),
+	}
+	if ts := urlParams["ts"]; ts != "" {
+		if sec, err := strconv.ParseInt(ts, 10, 64); err == nil {
+			m.LastModified = time.Unix(sec, 0).UTC()
+		}
+	}
+	if ttl := urlParams["ttl"]; ttl != "" {
+		d, err := ParseTTL(ttl)
+		if err != nil {
+			return dhttp.NewError(http.StatusBadRequest, "invalid ttl: %s", ttl)
+		}
+		m.Expires = time.Now().Add(d).UTC()
+	}
+
+	f, err := ioutil.TempFile(l.dir, ".upload-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	n, err := io.Copy(f, body)
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return dhttp.NewError(http.StatusBadRequest, "empty body uploaded").WithReason("empty")
+	}
+
+	mb, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(path+".json", mb, 0600); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Put is the same as Upload since there's nothing to look up
+func (l *Local) Put(filename string, body io.Reader, ct string, urlParams map[string]string) error {
+	ar, err := seaweed.NewAssignResult("", filename)
+	if err != nil {
+		return dhttp.NewError(http.StatusBadRequest,
+			"invalid filename sent: %s", filename)
+	}
+	return l.Upload(ar, body, ct, urlParams)
+}
+
+// open returns the file and its meta or a 404 error if it doesn't exist or
+// has expired
+func (l *Local) open(filename string) (*os.File, *localMeta, error) {
+	ar, err := seaweed.NewAssignResult("", filename)
+	if err != nil {
+		return nil, nil, dhttp.NewError(http.StatusBadRequest,
+			"invalid filename sent: %s", filename)
+	}
+	path, err := l.path(ar.FID())
+	if err != nil {
+		return nil, nil, dhttp.NewError(http.StatusBadRequest,
+			"invalid filename sent: %s", filename)
+	}
+	notFound := dhttp.NewError(http.StatusNotFound, "filename not found: %s", filename)
+
+	mb, err := ioutil.ReadFile(path + ".json")
+	if os.IsNotExist(err) {
+		return nil, nil, notFound
+	} else if err != nil {
+		return nil, nil, err
+	}
+	m := &localMeta{}
+	if err = json.Unmarshal(mb, m); err != nil {
+		return nil, nil, err
+	}
+	if !m.Expires.IsZero() && time.Now().After(m.Expires) {
+		return nil, nil, notFound
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil, notFound
+	} else if err != nil {
+		return nil, nil, err
+	}
+	return f, m, nil
+}
+
+// Get returns the file. headers and urlParams are ignored
+func (l *Local) Get(filename string, headers, urlParams map[string]string) (io.ReadCloser, *http.Header, error) {
+	f, m, err := l.open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	h := http.Header{}
+	h.Set("Content-Type", m.ContentType)
+	h.Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
+	h.Set("Last-Modified", m.LastModified.Format(http.TimeFormat))
+	if !m.Expires.IsZero() {
+		h.Set("Expires", m.Expires.Format(http.TimeFormat))
+	}
+	return f, &h, nil
+}
+
+// Exists returns whether the file exists and hasn't expired
+func (l *Local) Exists(filename string) (bool, error) {
+	f, _, err := l.open(filename)
+	if he, ok := err.(dhttp.HTTPError); ok && he.Code() == http.StatusNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	f.Close()
+	return true, nil
+}
+
+// Lookup always returns an error since the files can't be downloaded except
+// through dank
+func (l *Local) Lookup(filename string, urlParams map[string]string) (string, error) {
+	return "", dhttp.NewError(http.StatusNotImplemented,
+		"files in the local backend can't be downloaded directly")
+}
+
+// Delete removes the file
+func (l *Local) Delete(filename string) error {
+	f, _, err := l.open(filename)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	if err = os.Remove(f.Name()); err != nil {
+		return err
+	}
+	return os.Remove(f.Name() + ".json")
+}
+
+// Ready returns an error if the directory can't be read
+func (l *Local) Ready() error {
+	_, err := os.Stat(l.dir)
+	return err
+}
@@ -0,0 +1,110 @@
+package backend
+
+import (
+	. "testing"
+
+	"bytes"
+	"encoding/base64"
+	"github.com/levenlabs/dank/seaweed"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+)
+
+func testLocal(t *T) *Local {
+	dir, err := ioutil.TempDir("", "dank-local-test-")
+	require.Nil(t, err)
+	l, err := NewLocal(dir)
+	require.Nil(t, err)
+	return l
+}
+
+func TestLocal(t *T) {
+	l := testLocal(t)
+	defer os.RemoveAll(l.dir)
+
+	ars, err := l.Assign("", "", 2)
+	require.Nil(t, err)
+	require.Len(t, ars, 2)
+	assert.Equal(t, ars[0].FID()+"_1", ars[1].FID())
+	f := ars[0].Filename() + ".txt"
+
+	ok, err := l.Exists(f)
+	require.Nil(t, err)
+	assert.False(t, ok)
+
+	err = l.Upload(ars[0], bytes.NewBufferString("hello"), "text/plain", map[string]string{"ts": "1476719000"})
+	require.Nil(t, err)
+	ok, err = l.Exists(f)
+	require.Nil(t, err)
+	assert.True(t, ok)
+
+	body, h, err := l.Get(f, nil, nil)
+	require.Nil(t, err)
+	b, err := ioutil.ReadAll(body)
+	body.Close()
+	require.Nil(t, err)
+	assert.Equal(t, "hello", string(b))
+	assert.Equal(t, "text/plain", h.Get("Content-Type"))
+	assert.Equal(t, "5", h.Get("Content-Length"))
+	assert.Equal(t, time.Unix(1476719000, 0).UTC().Format(http.TimeFormat), h.Get("Last-Modified"))
+
+	// empty bodies are rejected like with seaweed
+	assert.NotNil(t, l.Upload(ars[1], &bytes.Buffer{}, "", nil))
+
+	require.Nil(t, l.Delete(f))
+	ok, err = l.Exists(f)
+	require.Nil(t, err)
+	assert.False(t, ok)
+	assert.NotNil(t, l.Delete(f))
+}
+
+func TestLocalTTL(t *T) {
+	l := testLocal(t)
+	defer os.RemoveAll(l.dir)
+
+	ars, err := l.Assign("", "1m", 1)
+	require.Nil(t, err)
+	f := ars[0].Filename()
+	err = l.Upload(ars[0], bytes.NewBufferString("hello"), "", map[string]string{"ttl": "1m"})
+	require.Nil(t, err)
+	body, h, err := l.Get(f, nil, nil)
+	require.Nil(t, err)
+	body.Close()
+	assert.Equal(t, "1m", TTL(h))
+	assert.Equal(t, "", TTL(&http.Header{}))
+
+	// pretend the minute passed
+	p, _ := l.path(ars[0].FID())
+	m := `{"contentType":"","lastModified":"2016-10-17T15:43:20Z","expires":"2016-10-17T15:44:20Z"}`
+	require.Nil(t, ioutil.WriteFile(p+".json", []byte(m), 0600))
+	_, _, err = l.open(f)
+	assert.NotNil(t, err)
+}
+
+func TestLocalInvalidFID(t *T) {
+	l := testLocal(t)
+	defer os.RemoveAll(l.dir)
+
+	f := base64.URLEncoding.EncodeToString([]byte("../../etc/passwd"))
+	_, _, err := l.Get(f, nil, nil)
+	assert.NotNil(t, err)
+	err = l.Upload(seaweed.NewRawAssignResult("", "1,../x"), bytes.NewBufferString("x"), "", nil)
+	assert.NotNil(t, err)
+}
+
+func TestParseTTL(t *T) {
+	d, err := ParseTTL("3m")
+	require.Nil(t, err)
+	assert.Equal(t, 3*time.Minute, d)
+	d, err = ParseTTL("2w")
+	require.Nil(t, err)
+	assert.Equal(t, 14*24*time.Hour, d)
+	for _, ttl := range []string{"", "m", "0d", "5", "5s"} {
+		_, err = ParseTTL(ttl)
+		assert.NotNil(t, err, ttl)
+	}
+}
@@ -0,0 +1,291 @@
+package backend
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	dhttp "github.com/levenlabs/dank/http"
+	"github.com/levenlabs/dank/seaweed"
+	"github.com/levenlabs/go-llog"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3 object metadata headers that hold what seaweed would've stored about the
+// file but S3 doesn't have a place for
+const (
+	s3LastModifiedHeader = "X-Amz-Meta-Dank-Last-Modified"
+	s3ExpiresHeader      = "X-Amz-Meta-Dank-Expires"
+)
+
+var errS3Status = errors.New("unexpected s3 status")
+
+// S3 is a Backend that stores files as objects in a bucket of an S3 compatible
+// service, like MinIO or seaweed's S3 gateway, using path-style urls. Objects
+// are named after their fid
+type S3 struct {
+	endpoint    *url.URL
+	bucket      string
+	signer      *sigV4Signer
+	redirectTTL time.Duration
+}
+
+// NewS3 returns an S3 storing files in bucket at endpoint, like
+// http://127.0.0.1:9000. Urls returned from Lookup are presigned for
+// redirectTTL
+func NewS3(endpoint, bucket, region, accessKey, secretKey string, redirectTTL time.Duration) (*S3, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	} else if u.Scheme == "" || u.Host == "" {
+		return nil, errors.New("s3 endpoint must be a url like http://host:port")
+	}
+	if bucket == "" {
+		return nil, errors.New("s3 bucket is required")
+	}
+	return &S3{
+		endpoint: u,
+		bucket:   bucket,
+		signer: &sigV4Signer{
+			accessKey: accessKey,
+			secretKey: secretKey,
+			region:    region,
+		},
+		redirectTTL: redirectTTL,
+	}, nil
+}
+
+// objectURL returns the url of the object for the fid, or of the bucket if
+// fid is empty
+func (s *S3) objectURL(fid string) *url.URL {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.bucket
+	if fid != "" {
+		u.Path += "/" + fid
+	}
+	u.RawQuery = ""
+	return &u
+}
+
+// filenameURL returns the url of the object for filename
+func (s *S3) filenameURL(filename string) (*url.URL, error) {
+	ar, err := seaweed.NewAssignResult("", filename)
+	if err != nil || !fidRegex.MatchString(ar.FID()) {
+		return nil, dhttp.NewError(http.StatusBadRequest,
+			"invalid filename sent: %s", filename)
+	}
+	return s.objectURL(ar.FID()), nil
+}
+
+// do signs and makes the request. If the response doesn't have one of the
+// expectedCodes then it's closed and an error is returned, which is a 404
+// HTTPError for a 404
+func (s *S3) do(req *http.Request, payloadHash string, filename string, expectedCodes ...int) (*http.Response, error) {
+	s.signer.sign(req, payloadHash, time.Now())
+	kv := llog.KV{
+		"method": req.Method,
+		"url":    req.URL.String(),
+	}
+	llog.Debug("making s3 request", kv)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		kv["error"] = err
+		llog.Warn("error making s3 http request", kv)
+		return nil, err
+	}
+	for _, c := range expectedCodes {
+		if resp.StatusCode == c {
+			return resp, nil
+		}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && filename != "" {
+		return nil, dhttp.NewError(http.StatusNotFound, "filename not found: %s", filename)
+	}
+	// errors are xml but the body is short enough to just log it
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	kv["status"] = resp.Status
+	kv["body"] = string(body)
+	llog.Warn("invalid s3 status", kv)
+	return nil, errS3Status
+}
+
+// expired returns true if the object with the headers had a ttl that passed
+func expired(h http.Header) bool {
+	sec, err := strconv.ParseInt(h.Get(s3ExpiresHeader), 10, 64)
+	return err == nil && time.Now().Unix() > sec
+}
+
+// Assign returns count new fids. The replication is ignored
+func (s *S3) Assign(replication, ttl string, count int) ([]*seaweed.AssignResult, error) {
+	return newFIDs(count)
+}
+
+// Upload PUTs body as the object for the file. S3 needs the length and hash of
+// the body before it's sent so it's copied to a temporary file first. The ttl
+// and ts in urlParams are stored in the object's metadata since objects can't
+// expire individually. Expired objects are treated as not existing but need a
+// bucket lifecycle rule to actually be removed
+func (s *S3) Upload(r *seaweed.AssignResult, body io.Reader, ct string, urlParams map[string]string) error {
+	if !fidRegex.MatchString(r.FID()) {
+		return dhttp.NewError(http.StatusBadRequest,
+			"invalid filename sent: %s", r.Filename())
+	}
+	h := http.Header{}
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+	h.Set("Content-Type", ct)
+	lastModified := time.Now()
+	if ts := urlParams["ts"]; ts != "" {
+		if sec, err := strconv.ParseInt(ts, 10, 64); err == nil {
+			lastModified = time.Unix(sec, 0)
+		}
+	}
+	h.Set(s3LastModifiedHeader, strconv.FormatInt(lastModified.Unix(), 10))
+	if ttl := urlParams["ttl"]; ttl != "" {
+		d, err := ParseTTL(ttl)
+		if err != nil {
+			return dhttp.NewError(http.StatusBadRequest, "invalid ttl: %s", ttl)
+		}
+		h.Set(s3ExpiresHeader, strconv.FormatInt(time.Now().Add(d).Unix(), 10))
+	}
+
+	f, err := ioutil.TempFile("", "dank-s3-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, hash), body)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return dhttp.NewError(http.StatusBadRequest, "empty body uploaded").WithReason("empty")
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("PUT", s.objectURL(r.FID()).String(), ioutil.NopCloser(f))
+	if err != nil {
+		return err
+	}
+	req.Header = h
+	req.ContentLength = n
+	resp, err := s.do(req, hex.EncodeToString(hash.Sum(nil)), r.Filename(), http.StatusOK)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Put is the same as Upload since there's nothing to look up
+func (s *S3) Put(filename string, body io.Reader, ct string, urlParams map[string]string) error {
+	ar, err := seaweed.NewAssignResult("", filename)
+	if err != nil {
+		return dhttp.NewError(http.StatusBadRequest,
+			"invalid filename sent: %s", filename)
+	}
+	return s.Upload(ar, body, ct, urlParams)
+}
+
+// Get proxies the object. headers and urlParams are ignored
+func (s *S3) Get(filename string, headers, urlParams map[string]string) (io.ReadCloser, *http.Header, error) {
+	u, err := s.filenameURL(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := s.do(req, emptyPayload, filename, http.StatusOK)
+	if err != nil {
+		return nil, nil, err
+	}
+	if expired(resp.Header) {
+		resp.Body.Close()
+		return nil, nil, dhttp.NewError(http.StatusNotFound, "filename not found: %s", filename)
+	}
+	if sec, err := strconv.ParseInt(resp.Header.Get(s3LastModifiedHeader), 10, 64); err == nil {
+		resp.Header.Set("Last-Modified", time.Unix(sec, 0).UTC().Format(http.TimeFormat))
+	}
+	if sec, err := strconv.ParseInt(resp.Header.Get(s3ExpiresHeader), 10, 64); err == nil {
+		resp.Header.Set("Expires", time.Unix(sec, 0).UTC().Format(http.TimeFormat))
+	}
+	return resp.Body, &resp.Header, nil
+}
+
+// Exists returns whether the object exists and hasn't expired
+func (s *S3) Exists(filename string) (bool, error) {
+	u, err := s.filenameURL(filename)
+	if err != nil {
+		return false, err
+	}
+	req, err := http.NewRequest("HEAD", u.String(), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := s.do(req, emptyPayload, filename, http.StatusOK, http.StatusNotFound)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK && !expired(resp.Header), nil
+}
+
+// Lookup returns a presigned url for the object that's valid for the
+// redirectTTL passed to NewS3. The ttl isn't checked since that would need a
+// request to S3
+func (s *S3) Lookup(filename string, urlParams map[string]string) (string, error) {
+	u, err := s.filenameURL(filename)
+	if err != nil {
+		return "", err
+	}
+	return s.signer.presign(u, s.redirectTTL, time.Now()), nil
+}
+
+// Delete removes the object. S3 doesn't say whether the object existed so
+// this never returns a 404
+func (s *S3) Delete(filename string) error {
+	u, err := s.filenameURL(filename)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("DELETE", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req, emptyPayload, filename, http.StatusNoContent, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Ready returns an error if the bucket can't be reached
+func (s *S3) Ready() error {
+	req, err := http.NewRequest("HEAD", s.objectURL("").String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req, emptyPayload, "", http.StatusOK)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
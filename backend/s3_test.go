@@ -0,0 +1,114 @@
+package backend
+
+import (
+	. "testing"
+
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+type testObject struct {
+	body   []byte
+	header http.Header
+}
+
+// testS3Server is the smallest part of S3 that the S3 backend uses
+func testS3Server(t *T) *httptest.Server {
+	var mu sync.Mutex
+	objects := map[string]*testObject{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/"))
+		if r.URL.Path == "/bucket" {
+			return
+		}
+		switch r.Method {
+		case "PUT":
+			b, _ := ioutil.ReadAll(r.Body)
+			assert.Equal(t, hashHex(b), r.Header.Get("X-Amz-Content-Sha256"))
+			objects[r.URL.Path] = &testObject{body: b, header: r.Header}
+		case "GET", "HEAD":
+			o, ok := objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			for _, k := range []string{"Content-Type", s3LastModifiedHeader, s3ExpiresHeader} {
+				if v := o.header.Get(k); v != "" {
+					w.Header().Set(k, v)
+				}
+			}
+			w.Write(o.body)
+		case "DELETE":
+			delete(objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+}
+
+func TestS3(t *T) {
+	srv := testS3Server(t)
+	defer srv.Close()
+	s, err := NewS3(srv.URL, "bucket", "us-east-1", "key", "secret", time.Minute)
+	require.Nil(t, err)
+	require.Nil(t, s.Ready())
+
+	ars, err := s.Assign("", "", 1)
+	require.Nil(t, err)
+	f := ars[0].Filename() + ".txt"
+	ok, err := s.Exists(f)
+	require.Nil(t, err)
+	assert.False(t, ok)
+
+	err = s.Upload(ars[0], bytes.NewBufferString("hello"), "text/plain", map[string]string{"ts": "1476719000"})
+	require.Nil(t, err)
+	ok, err = s.Exists(f)
+	require.Nil(t, err)
+	assert.True(t, ok)
+
+	body, h, err := s.Get(f, nil, nil)
+	require.Nil(t, err)
+	b, err := ioutil.ReadAll(body)
+	body.Close()
+	require.Nil(t, err)
+	assert.Equal(t, "hello", string(b))
+	assert.Equal(t, "text/plain", h.Get("Content-Type"))
+	assert.Equal(t, time.Unix(1476719000, 0).UTC().Format(http.TimeFormat), h.Get("Last-Modified"))
+
+	u, err := s.Lookup(f, nil)
+	require.Nil(t, err)
+	assert.True(t, strings.HasPrefix(u, srv.URL+"/bucket/"+ars[0].FID()+"?"))
+	assert.Contains(t, u, "X-Amz-Expires=60")
+	assert.Contains(t, u, "X-Amz-Signature=")
+
+	require.Nil(t, s.Delete(f))
+	_, _, err = s.Get(f, nil, nil)
+	assert.NotNil(t, err)
+}
+
+func TestS3Expired(t *T) {
+	srv := testS3Server(t)
+	defer srv.Close()
+	s, err := NewS3(srv.URL, "bucket", "us-east-1", "key", "secret", time.Minute)
+	require.Nil(t, err)
+
+	ars, err := s.Assign("", "", 1)
+	require.Nil(t, err)
+	err = s.Upload(ars[0], bytes.NewBufferString("hello"), "", map[string]string{"ttl": "1m"})
+	require.Nil(t, err)
+	ok, err := s.Exists(ars[0].Filename())
+	require.Nil(t, err)
+	assert.True(t, ok)
+
+	h := http.Header{}
+	h.Set(s3ExpiresHeader, "1476719000")
+	assert.True(t, expired(h))
+}
@@ -0,0 +1,49 @@
+package backend
+
+import (
+	"github.com/levenlabs/dank/seaweed"
+	"io"
+	"net/http"
+)
+
+type seaweedBackend struct {
+	c *seaweed.Client
+}
+
+// Seaweed returns a Backend that stores files in the seaweed cluster c talks
+// to
+func Seaweed(c *seaweed.Client) Backend {
+	return seaweedBackend{c}
+}
+
+func (b seaweedBackend) Assign(replication, ttl string, count int) ([]*seaweed.AssignResult, error) {
+	return b.c.AssignN(replication, ttl, count)
+}
+
+func (b seaweedBackend) Upload(r *seaweed.AssignResult, body io.Reader, ct string, urlParams map[string]string) error {
+	return b.c.Upload(r, body, ct, urlParams)
+}
+
+func (b seaweedBackend) Put(filename string, body io.Reader, ct string, urlParams map[string]string) error {
+	return b.c.Put(filename, body, ct, urlParams)
+}
+
+func (b seaweedBackend) Get(filename string, headers, urlParams map[string]string) (io.ReadCloser, *http.Header, error) {
+	return b.c.Get(filename, headers, urlParams)
+}
+
+func (b seaweedBackend) Exists(filename string) (bool, error) {
+	return b.c.Exists(filename)
+}
+
+func (b seaweedBackend) Lookup(filename string, urlParams map[string]string) (string, error) {
+	return b.c.Lookup(filename, urlParams)
+}
+
+func (b seaweedBackend) Delete(filename string) error {
+	return b.c.Delete(filename)
+}
+
+func (b seaweedBackend) Ready() error {
+	return b.c.Ready()
+}
@@ -1,21 +1,109 @@
-// Package config provides for all configurable parameters the instance can have
+// Package config provides for all configurable parameters the instance can
+// have. Nothing is parsed when the package is imported, main calls Parse to
+// read the command line and other programs embedding dank can use New
 package config
 
 import (
-	"github.com/levenlabs/go-llog"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"github.com/mediocregopher/lever"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
 )
 
-// All possible configurable variables
-var (
+// Key is a single secret in the keyring. The ID is stamped into every
+// signature made with the key so the right key can be found when decoding
+type Key struct {
+	ID     string
+	Secret string
+}
+
+// Config holds all of the configurable parameters. See Parse for what each
+// one means
+type Config struct {
 	ListenAddr  string
 	SeaweedAddr string
-	Secret      string
+	Keyring     []Key
 	SkyAPIAddr  string
 	LogLevel    string
-)
+	ReplayFile  string
+	ReplayTTL   time.Duration
+	MetaFile    string
+	ChunkDir    string
+	ChunkTTL    time.Duration
+	Backend     string
+	BackendDir  string
+
+	S3Endpoint    string
+	S3Bucket      string
+	S3Region      string
+	S3AccessKey   string
+	S3SecretKey   string
+	S3RedirectTTL time.Duration
+
+	HealthTimeout  time.Duration
+	HealthCacheTTL time.Duration
 
-func init() {
+	LookupCacheTTL  time.Duration
+	LookupCacheSize int
+
+	CORSOrigins   []string
+	CORSMethods   []string
+	CORSHeaders   []string
+	CORSMaxAge    time.Duration
+	CORSEndpoints []string
+}
+
+// New returns a Config with the same defaults as Parse, except that there's no
+// Keyring, which must be set before the Config is used
+func New() *Config {
+	return &Config{
+		ListenAddr:      ":8333",
+		SeaweedAddr:     "127.0.0.1:9333",
+		LogLevel:        "info",
+		ReplayTTL:       720 * time.Hour,
+		ChunkDir:        filepath.Join(os.TempDir(), "dank-chunks"),
+		ChunkTTL:        24 * time.Hour,
+		Backend:         "seaweed",
+		BackendDir:      filepath.Join(os.TempDir(), "dank-files"),
+		S3Region:        "us-east-1",
+		S3RedirectTTL:   15 * time.Minute,
+		HealthTimeout:   2 * time.Second,
+		HealthCacheTTL:  5 * time.Second,
+		LookupCacheTTL:  time.Minute,
+		LookupCacheSize: 10000,
+		CORSHeaders:     []string{"Content-Type"},
+		CORSMaxAge:      10 * time.Minute,
+	}
+}
+
+// Validate returns an error if the Config is missing anything required
+func (c *Config) Validate() error {
+	if len(c.Keyring) == 0 {
+		return errors.New("a secret is required")
+	}
+	switch c.Backend {
+	case "seaweed":
+		if c.SeaweedAddr == "" {
+			return errors.New("the seaweed addr is required")
+		}
+	case "local", "s3":
+	default:
+		return fmt.Errorf("unknown backend %q", c.Backend)
+	}
+	return nil
+}
+
+// Parse reads the Config from the command line, environment and config file
+// using lever
+func Parse() (*Config, error) {
+	c := New()
 	l := lever.New("dank", nil)
 	l.Add(lever.Param{
 		Name:        "--listen-addr",
@@ -29,8 +117,46 @@ func init() {
 	})
 	l.Add(lever.Param{
 		Name:        "--secret",
-		Description: "Secret used to sign the signature when uploading. Must be 16 characters.",
-		Default:     "uShouldChangThis",
+		Description: "Secret used to sign the signature when uploading, with the key id 0. It's used as is, even if it contains a colon, and can be any length or @path to read a hex or base64 encoded key from a file. If --key is also sent then it's only used to verify existing signatures. Defaults to uShouldChangThis if neither is sent.",
+	})
+	l.Add(lever.Param{
+		Name:        "--key",
+		Description: "Secret with an explicit key id, in the form id:secret, where the secret is like --secret. Can be specified multiple times, the first is used to sign and the rest are only used to verify existing signatures.",
+	})
+	l.Add(lever.Param{
+		Name:        "--backend",
+		Description: "Where files are stored, either seaweed, s3 or local. s3 stores files in --s3-bucket. local stores files in --backend-dir and is meant for development and tests",
+		Default:     "seaweed",
+	})
+	l.Add(lever.Param{
+		Name:        "--backend-dir",
+		Description: "Directory files are stored in when --backend is local. Unset means a dank-files directory in the system's temporary directory",
+	})
+	l.Add(lever.Param{
+		Name:        "--s3-endpoint",
+		Description: "URL of the S3 compatible service when --backend is s3, like http://127.0.0.1:9000. Path-style urls are used",
+	})
+	l.Add(lever.Param{
+		Name:        "--s3-bucket",
+		Description: "Bucket files are stored in when --backend is s3",
+	})
+	l.Add(lever.Param{
+		Name:        "--s3-region",
+		Description: "Region used to sign requests when --backend is s3",
+		Default:     "us-east-1",
+	})
+	l.Add(lever.Param{
+		Name:        "--s3-access-key",
+		Description: "Access key used to sign requests when --backend is s3",
+	})
+	l.Add(lever.Param{
+		Name:        "--s3-secret-key",
+		Description: "Secret key used to sign requests when --backend is s3",
+	})
+	l.Add(lever.Param{
+		Name:        "--s3-redirect-ttl",
+		Description: "How long the presigned urls returned for X-Upstream-Redirect are valid when --backend is s3",
+		Default:     "15m",
 	})
 	l.Add(lever.Param{
 		Name:        "--skyapi-addr",
@@ -41,13 +167,213 @@ func init() {
 		Description: "Minimum log level to show, either debug, info, warn, error, or fatal",
 		Default:     "info",
 	})
+	l.Add(lever.Param{
+		Name:        "--replay-file",
+		Description: "File used to remember which signatures have been used so they survive restarts. Unset means only remember them in memory",
+	})
+	l.Add(lever.Param{
+		Name:        "--replay-ttl",
+		Description: "How long to remember a used signature that has no expiration",
+		Default:     "720h",
+	})
+	l.Add(lever.Param{
+		Name:        "--meta-file",
+		Description: "File used to store information about uploaded files, like their original name and who uploaded them, so it survives restarts. Unset means only keep it in memory",
+	})
+	l.Add(lever.Param{
+		Name:        "--chunk-dir",
+		Description: "Directory that chunks of resumable uploads are stored in until the upload is complete. Unset means a dank-chunks directory in the system's temporary directory",
+	})
+	l.Add(lever.Param{
+		Name:        "--chunk-ttl",
+		Description: "How long an unfinished resumable upload is kept after its last chunk",
+		Default:     "24h",
+	})
+	l.Add(lever.Param{
+		Name:        "--health-timeout",
+		Description: "How long /readyz waits for the seaweed master to respond",
+		Default:     "2s",
+	})
+	l.Add(lever.Param{
+		Name:        "--health-cache",
+		Description: "How long the result of checking the seaweed master is reused by /readyz",
+		Default:     "5s",
+	})
+	l.Add(lever.Param{
+		Name:        "--lookup-cache-ttl",
+		Description: "How long the locations of a seaweed volume are cached before looking them up on the master again. 0 disables the cache",
+		Default:     "1m",
+	})
+	l.Add(lever.Param{
+		Name:        "--lookup-cache-size",
+		Description: "Maximum number of seaweed volumes to cache the locations of",
+		Default:     "10000",
+	})
+	l.Add(lever.Param{
+		Name:        "--cors-origins",
+		Description: "Comma separated list of origins, like https://example.com, allowed to make CORS requests. * allows any origin. Unset means CORS is disabled",
+	})
+	l.Add(lever.Param{
+		Name:        "--cors-methods",
+		Description: "Comma separated list of methods allowed in CORS requests. Only the ones an endpoint also accepts are sent for it. Unset means every method the endpoint accepts",
+	})
+	l.Add(lever.Param{
+		Name:        "--cors-headers",
+		Description: "Comma separated list of request headers allowed in CORS requests",
+		Default:     "Content-Type",
+	})
+	l.Add(lever.Param{
+		Name:        "--cors-max-age",
+		Description: "How long browsers can cache the response to a CORS preflight request",
+		Default:     "10m",
+	})
+	l.Add(lever.Param{
+		Name:        "--cors-endpoints",
+		Description: "Comma separated list of endpoints, like /upload,/get, that CORS is enabled on. An endpoint also includes any paths under it. Unset means every endpoint",
+	})
 	l.Parse()
 
-	ListenAddr, _ = l.ParamStr("--listen-addr")
-	SeaweedAddr, _ = l.ParamStr("--seaweed-addr")
-	Secret, _ = l.ParamStr("--secret")
-	SkyAPIAddr, _ = l.ParamStr("--skyapi-addr")
-	LogLevel, _ = l.ParamStr("--log-level")
+	c.ListenAddr, _ = l.ParamStr("--listen-addr")
+	c.SeaweedAddr, _ = l.ParamStr("--seaweed-addr")
+	secret, _ := l.ParamStr("--secret")
+	keys, _ := l.ParamStrs("--key")
+	c.SkyAPIAddr, _ = l.ParamStr("--skyapi-addr")
+	c.Backend, _ = l.ParamStr("--backend")
+	backendDir, _ := l.ParamStr("--backend-dir")
+	c.S3Endpoint, _ = l.ParamStr("--s3-endpoint")
+	c.S3Bucket, _ = l.ParamStr("--s3-bucket")
+	c.S3Region, _ = l.ParamStr("--s3-region")
+	c.S3AccessKey, _ = l.ParamStr("--s3-access-key")
+	c.S3SecretKey, _ = l.ParamStr("--s3-secret-key")
+	s3RedirectTTL, _ := l.ParamStr("--s3-redirect-ttl")
+	c.LogLevel, _ = l.ParamStr("--log-level")
+	c.ReplayFile, _ = l.ParamStr("--replay-file")
+	replayTTL, _ := l.ParamStr("--replay-ttl")
+	c.MetaFile, _ = l.ParamStr("--meta-file")
+	chunkDir, _ := l.ParamStr("--chunk-dir")
+	chunkTTL, _ := l.ParamStr("--chunk-ttl")
+	healthTimeout, _ := l.ParamStr("--health-timeout")
+	healthCache, _ := l.ParamStr("--health-cache")
+	lookupCacheTTL, _ := l.ParamStr("--lookup-cache-ttl")
+	c.LookupCacheSize, _ = l.ParamInt("--lookup-cache-size")
+	corsOrigins, _ := l.ParamStr("--cors-origins")
+	corsMethods, _ := l.ParamStr("--cors-methods")
+	corsHeaders, _ := l.ParamStr("--cors-headers")
+	corsMaxAge, _ := l.ParamStr("--cors-max-age")
+	corsEndpoints, _ := l.ParamStr("--cors-endpoints")
+
+	var err error
+	if secret == "" && len(keys) == 0 {
+		secret = "uShouldChangThis"
+	}
+	if c.Keyring, err = ParseKeyring(secret, keys); err != nil {
+		return nil, fmt.Errorf("invalid --secret or --key: %s", err)
+	}
+	durations := []struct {
+		name string
+		str  string
+		d    *time.Duration
+	}{
+		{"--replay-ttl", replayTTL, &c.ReplayTTL},
+		{"--chunk-ttl", chunkTTL, &c.ChunkTTL},
+		{"--health-timeout", healthTimeout, &c.HealthTimeout},
+		{"--health-cache", healthCache, &c.HealthCacheTTL},
+		{"--lookup-cache-ttl", lookupCacheTTL, &c.LookupCacheTTL},
+		{"--s3-redirect-ttl", s3RedirectTTL, &c.S3RedirectTTL},
+		{"--cors-max-age", corsMaxAge, &c.CORSMaxAge},
+	}
+	for _, d := range durations {
+		if *d.d, err = time.ParseDuration(d.str); err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", d.name, err)
+		}
+	}
+	if backendDir != "" {
+		c.BackendDir = backendDir
+	}
+	if chunkDir != "" {
+		c.ChunkDir = chunkDir
+	}
+	c.CORSOrigins = splitList(corsOrigins)
+	c.CORSMethods = splitList(strings.ToUpper(corsMethods))
+	c.CORSHeaders = splitList(corsHeaders)
+	c.CORSEndpoints = splitList(corsEndpoints)
+	return c, c.Validate()
+}
+
+// splitList splits a comma separated list, ignoring any whitespace and empty
+// entries
+func splitList(s string) []string {
+	var l []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l = append(l, v)
+		}
+	}
+	return l
+}
+
+var keyIDRegex = regexp.MustCompile("^[A-Za-z0-9_-]+$")
+
+// readKeyFile reads a key from a file. The contents can be hex or base64
+// encoded and surrounding whitespace is ignored
+func readKeyFile(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	str := strings.TrimSpace(string(b))
+	if k, err := hex.DecodeString(str); err == nil {
+		return string(k), nil
+	}
+	for _, enc := range []*base64.Encoding{
+		base64.StdEncoding,
+		base64.URLEncoding,
+		base64.RawStdEncoding,
+		base64.RawURLEncoding,
+	} {
+		if k, err := enc.DecodeString(str); err == nil {
+			return string(k), nil
+		}
+	}
+	return "", fmt.Errorf("key in %s is not hex or base64 encoded", path)
+}
+
+// ParseKeyring turns each "id:secret" string in keys into a Key, in order, and
+// then adds secret, if it's not empty, with the id "0". secret is never split
+// on a colon so secrets from before there were key ids keep working. A secret
+// starting with @ is read from a file containing a hex or base64 encoded key
+func ParseKeyring(secret string, keys []string) ([]Key, error) {
+	ks := make([]Key, 0, len(keys)+1)
+	for _, s := range keys {
+		i := strings.Index(s, ":")
+		if i < 0 {
+			return nil, errors.New("key isn't in the form id:secret")
+		}
+		ks = append(ks, Key{ID: s[:i], Secret: s[i+1:]})
+	}
+	if secret != "" {
+		ks = append(ks, Key{ID: "0", Secret: secret})
+	}
 
-	llog.SetLevelFromString(LogLevel)
+	ids := map[string]bool{}
+	for i := range ks {
+		k := &ks[i]
+		if !keyIDRegex.MatchString(k.ID) {
+			return nil, fmt.Errorf("invalid key id %q", k.ID)
+		}
+		if ids[k.ID] {
+			return nil, fmt.Errorf("duplicate key id %q", k.ID)
+		}
+		if strings.HasPrefix(k.Secret, "@") {
+			var err error
+			if k.Secret, err = readKeyFile(k.Secret[1:]); err != nil {
+				return nil, err
+			}
+		}
+		if k.Secret == "" {
+			return nil, fmt.Errorf("empty secret for key id %q", k.ID)
+		}
+		ids[k.ID] = true
+	}
+	return ks, nil
 }
@@ -0,0 +1,47 @@
+package config
+
+import (
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *T) {
+	c := New()
+	assert.NotNil(t, c.Validate())
+
+	var err error
+	c.Keyring, err = ParseKeyring("old", []string{"new:secret"})
+	require.Nil(t, err)
+	assert.Equal(t, []Key{{ID: "new", Secret: "secret"}, {ID: "0", Secret: "old"}}, c.Keyring)
+	assert.Nil(t, c.Validate())
+
+	c.SeaweedAddr = ""
+	assert.NotNil(t, c.Validate())
+	c.Backend = "local"
+	assert.Nil(t, c.Validate())
+	c.Backend = "other"
+	assert.NotNil(t, c.Validate())
+
+	_, err = ParseKeyring("", []string{"a:1", "a:2"})
+	assert.NotNil(t, err)
+	_, err = ParseKeyring("", []string{"a"})
+	assert.NotNil(t, err)
+	_, err = ParseKeyring("", []string{"a b:1"})
+	assert.NotNil(t, err)
+}
+
+func TestParseKeyringLegacy(t *T) {
+	// secrets from before key ids are used as is, even with a colon
+	ks, err := ParseKeyring("abc:defghijklmnop", nil)
+	require.Nil(t, err)
+	assert.Equal(t, []Key{{ID: "0", Secret: "abc:defghijklmnop"}}, ks)
+
+	ks, err = ParseKeyring("a:b:c", []string{"new:x:y"})
+	require.Nil(t, err)
+	assert.Equal(t, []Key{{ID: "new", Secret: "x:y"}, {ID: "0", Secret: "a:b:c"}}, ks)
+
+	_, err = ParseKeyring("old", []string{"0:new"})
+	assert.NotNil(t, err)
+}
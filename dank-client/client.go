@@ -95,6 +95,9 @@ func (d *Client) Upload(body []byte, a *types.Assignment) (string, error) {
 	q := u.Query()
 	q.Set("sig", a.Signature)
 	q.Set("filename", a.Filename)
+	if a.Owner != "" {
+		q.Set("owner", a.Owner)
+	}
 	u.RawQuery = q.Encode()
 
 	req, err := http.NewRequest("PUT", u.String(), newBody)
@@ -151,6 +154,34 @@ func (d *Client) Assign(ar *AssignOptions) (*types.Assignment, error) {
 	return a, err
 }
 
+// AssignN gets count assignments from seaweed with a single request. Each
+// assignment has its own signature but the same restrictions/options
+func (d *Client) AssignN(ar *AssignOptions, count int) ([]*types.Assignment, error) {
+	u, err := url.Parse("http://" + d.resolve() + "/assign")
+	if err != nil {
+		return nil, err
+	}
+	v := url.Values{}
+	if ar != nil {
+		v = ar.URLValues()
+	}
+	v.Set("count", strconv.Itoa(count))
+	u.RawQuery = v.Encode()
+
+	resp, err := http.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected code from dank: %d", resp.StatusCode)
+	}
+
+	var as []*types.Assignment
+	err = json.NewDecoder(resp.Body).Decode(&as)
+	return as, err
+}
+
 // Verify takes an Assignment and validates that the signature matches a valid
 // filename
 func (d *Client) Verify(a *types.Assignment) error {
@@ -161,6 +192,9 @@ func (d *Client) Verify(a *types.Assignment) error {
 	q := u.Query()
 	q.Set("sig", a.Signature)
 	q.Set("filename", a.Filename)
+	if a.Owner != "" {
+		q.Set("owner", a.Owner)
+	}
 	u.RawQuery = q.Encode()
 
 	resp, err := http.Get(u.String())
@@ -0,0 +1,659 @@
+package dank
+
+import (
+	"bytes"
+	"encoding/json"
+	dhttp "github.com/levenlabs/dank/http"
+	"github.com/levenlabs/dank/metrics"
+	"github.com/levenlabs/dank/transform"
+	"github.com/levenlabs/dank/types"
+	"github.com/levenlabs/go-llog"
+	"github.com/levenlabs/golib/rpcutil"
+	"github.com/vincent-petithory/dataurl"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type getArgs struct {
+	Filename string `json:"filename" mapstructure:"filename"`
+
+	// Signature is required for private files and comes from /sign-get
+	Signature string `json:"sig" mapstructure:"sig"`
+
+	// these transform the image before returning it, see transform.Options
+	WidthStr   string `json:"w" mapstructure:"w" validate:"regexp=^[0-9]*$"`
+	HeightStr  string `json:"h" mapstructure:"h" validate:"regexp=^[0-9]*$"`
+	Fit        string `json:"fit" mapstructure:"fit"`
+	Format     string `json:"format" mapstructure:"format"`
+	QualityStr string `json:"q" mapstructure:"q" validate:"regexp=^[0-9]*$"`
+}
+
+// transformParams are the query params used by getArgs to transform an image
+// that shouldn't be passed onto seaweed
+var transformParams = []string{"w", "h", "fit", "format", "q"}
+
+// transformOptions returns the transform.Options sent or nil if the image
+// shouldn't be transformed
+func (a *getArgs) transformOptions() (*transform.Options, error) {
+	if a.WidthStr == "" && a.HeightStr == "" && a.Fit == "" &&
+		a.Format == "" && a.QualityStr == "" {
+		return nil, nil
+	}
+	o := &transform.Options{
+		Fit:    a.Fit,
+		Format: a.Format,
+	}
+	o.Width, _ = strconv.Atoi(a.WidthStr)
+	o.Height, _ = strconv.Atoi(a.HeightStr)
+	o.Quality, _ = strconv.Atoi(a.QualityStr)
+	if err := o.Validate(); err != nil {
+		return nil, dhttp.NewError(http.StatusBadRequest,
+			"invalid transform sent: %s", err.Error())
+	}
+	return o, nil
+}
+
+var headersToSend = []string{
+	"If-Modified-Since",
+	"Accept",
+	"Accept-Encoding",
+	"Range",
+}
+
+var headersToCopy = []string{
+	"Content-Type",
+	"Last-Modified",
+	"Content-Encoding",
+	"Content-Length",
+	"Accept-Ranges",
+	"Content-Range",
+	"Expires",
+	"Cache-Control",
+	"Content-Disposition",
+}
+
+func (s *Server) getHandler(w http.ResponseWriter, r *http.Request, args *getArgs) (int, error) {
+	kv := rpcutil.RequestKV(r)
+	kv["filename"] = args.Filename
+	kv["method"] = r.Method
+	llog.Debug("received request to get", kv)
+
+	if args.Filename == "" {
+		return 404, nil
+	}
+
+	attach := false
+	up := r.URL.Query()
+	// don't pass on the filename param
+	if up.Get("filename") == args.Filename {
+		up.Del("filename")
+	}
+	if _, ok := up["attachment"]; ok {
+		attach = true
+	}
+	up.Del("sig")
+	for _, p := range transformParams {
+		up.Del(p)
+	}
+
+	if s.download.Private(args.Filename) {
+		err := s.download.Verify(args.Filename, args.Signature, rpcutil.RequestIP(r))
+		if err != nil {
+			kv["error"] = err
+			llog.Info("invalid signature for private file", kv)
+			return 0, dhttp.NewError(http.StatusForbidden, "valid signature required for private file")
+		}
+	}
+
+	// the original filename is still used for the attachment name
+	filename := args.Filename
+	o, err := args.transformOptions()
+	if err != nil {
+		return 0, err
+	}
+	if o != nil {
+		var img *transform.Image
+		filename, img, err = transform.Get(s.backend, s.download, s.uploader.MetaStore, args.Filename, o)
+		if err != nil {
+			kv["error"] = err
+			llog.Warn("error transforming file", kv)
+			return 0, err
+		}
+		kv["transformed"] = filename
+		if img != nil {
+			// it couldn't be stored so send it directly
+			w.Header().Set("Content-Type", img.ContentType)
+			w.Header().Set("Content-Length", strconv.Itoa(len(img.Data)))
+			if attach {
+				w.Header().Set("Content-Disposition",
+					"attachment; filename="+strconv.Quote(args.Filename))
+			}
+			if r.Method == "GET" {
+				n, _ := w.Write(img.Data)
+				metrics.Served(int64(n))
+			}
+			return 0, nil
+		}
+	}
+
+	code := 200
+	urlParams := dhttp.FirstQueryVals(up)
+	var body io.ReadCloser
+	if r.Method == "HEAD" && r.Header.Get("X-Upstream-Redirect") != "" {
+		var surl string
+		surl, err = s.backend.Lookup(filename, urlParams)
+		if err == nil {
+			w.Header().Set("Location", surl)
+			kv["url"] = surl
+			llog.Debug("returning location for upstream redirect", kv)
+			code = 307
+		}
+	} else {
+		hs := map[string]string{}
+		for _, n := range headersToSend {
+			v := r.Header.Get(n)
+			if v != "" {
+				hs[n] = v
+			}
+		}
+
+		var h *http.Header
+		body, h, err = s.backend.Get(filename, hs, urlParams)
+		if err == nil {
+			for _, n := range headersToCopy {
+				v := h.Get(n)
+				if v != "" {
+					w.Header().Set(n, v)
+				}
+			}
+			if body == nil {
+				code = http.StatusNotModified
+			} else if h.Get("Content-Range") != "" {
+				code = http.StatusPartialContent
+			}
+		}
+		if attach {
+			w.Header().Set("Content-Disposition",
+				"attachment; filename="+strconv.Quote(args.Filename))
+		}
+	}
+
+	if err != nil {
+		kv["error"] = err
+		llog.Warn("error getting file", kv)
+		return 0, err
+	}
+
+	if body != nil {
+		defer body.Close()
+
+		w.WriteHeader(code)
+		if r.Method == "GET" {
+			var n int64
+			n, err = io.Copy(w, body)
+			metrics.Served(n)
+			if err != nil {
+				kv["error"] = err
+				llog.Error("error copying body to writer", kv)
+			}
+		}
+		return 0, nil
+	}
+	return code, nil
+}
+
+func (s *Server) getPathHandler(w http.ResponseWriter, r *http.Request, args *getArgs) (int, error) {
+	if args.Filename == "" {
+		p := strings.Split(r.URL.Path, "/")
+		if len(p) < 3 || p[2] == "" {
+			return 404, nil
+		}
+		args.Filename = p[2]
+	}
+	return s.getHandler(w, r, args)
+}
+
+func (s *Server) assignHandler(w http.ResponseWriter, r *http.Request, args *types.AssignRequest) (int, error) {
+	kv := rpcutil.RequestKV(r)
+	kv["fileType"] = args.FileType
+	kv["maxSize"] = args.MaxSize
+	llog.Debug("received request to assign", kv)
+
+	// without a count a single assignment is returned instead of a list
+	var a interface{}
+	var err error
+	if args.CountStr == "" {
+		a, err = s.uploader.Assign(args)
+	} else {
+		kv["count"] = args.Count()
+		a, err = s.uploader.AssignN(args)
+	}
+	if err != nil {
+		kv["error"] = err
+		llog.Warn("error getting assign", kv)
+		return 0, err
+	}
+	js, err := json.Marshal(a)
+	if err != nil {
+		kv["error"] = err
+		llog.Warn("error running json.Marshal for assign result", kv)
+		// do not return the error to the client
+		return http.StatusInternalServerError, nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+	return 0, nil
+}
+
+type signGetArgs struct {
+	Filename      string `json:"filename" mapstructure:"filename" validate:"nonzero"`
+	SigExpiresStr string `json:"sigExpires" mapstructure:"sig_expires" validate:"regexp=^[0-9]*$"`
+	IP            string `json:"ip" mapstructure:"ip"`
+}
+
+type signGetRes struct {
+	Filename string `json:"filename"`
+	Sig      string `json:"sig"`
+	URL      string `json:"url"`
+	Expires  int64  `json:"expires"`
+}
+
+// defaultSignGetExpires is how long a signature from /sign-get lasts if
+// sig_expires isn't sent
+const defaultSignGetExpires = time.Hour
+
+func (s *Server) signGetHandler(w http.ResponseWriter, r *http.Request, args *signGetArgs) (int, error) {
+	kv := rpcutil.RequestKV(r)
+	kv["filename"] = args.Filename
+	kv["ip"] = args.IP
+	llog.Debug("received request to sign get", kv)
+
+	d := defaultSignGetExpires
+	if args.SigExpiresStr != "" {
+		i, _ := strconv.ParseInt(args.SigExpiresStr, 10, 64)
+		d = time.Duration(i) * time.Second
+	}
+	expires := time.Now().Add(d).Unix()
+	sig, err := s.download.Sign(args.Filename, expires, args.IP)
+	if err != nil {
+		kv["error"] = err
+		llog.Warn("error signing get", kv)
+		return 0, dhttp.NewError(http.StatusBadRequest, "invalid filename sent: %s", args.Filename)
+	}
+
+	js, err := json.Marshal(&signGetRes{
+		Filename: args.Filename,
+		Sig:      sig,
+		URL:      s.prefix + "/get/" + url.PathEscape(args.Filename) + "?" + url.Values{"sig": {sig}}.Encode(),
+		Expires:  expires,
+	})
+	if err != nil {
+		kv["error"] = err
+		llog.Warn("error running json.Marshal for sign get result", kv)
+		// do not return the error to the client
+		return http.StatusInternalServerError, nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+	return 0, nil
+}
+
+// since mapstructure doesn't support embedded structs, copying these here from
+// upload.Assignment
+type uploadArgs struct {
+	Signature    string `json:"sig" mapstructure:"sig"  validate:"nonzero"`
+	Filename     string `json:"filename"  mapstructure:"filename" validate:"nonzero"`
+	LastModified string `json:"lastModified" mapstructure:"last_modified"`
+	FormKey      string `json:"formKey" mapstructure:"form_key"`
+	Owner        string `json:"owner" mapstructure:"owner"`
+}
+
+type uploadRes struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"contentType"`
+	SHA256      string `json:"sha256"`
+	MD5         string `json:"md5"`
+}
+
+func (s *Server) uploadHandler(w http.ResponseWriter, r *http.Request, args *uploadArgs) (int, error) {
+	kv := rpcutil.RequestKV(r)
+	cl := r.ContentLength
+	kv["length"] = cl
+	kv["filename"] = args.Filename
+	kv["method"] = r.Method
+
+	ct := r.Header.Get("Content-Type")
+	kv["contentType"] = ct
+	// http/request.go's parsePostForm doesn't care about err so we shouldn't
+	mt, _, _ := mime.ParseMediaType(ct)
+	kv["mimeType"] = mt
+	llog.Debug("received request to upload file", kv)
+
+	body := r.Body
+	var name string
+	var err error
+	switch mt {
+	case "application/x-www-form-urlencoded":
+		fallthrough
+	case "multipart/form-data":
+		if args.FormKey == "" {
+			args.FormKey = "file"
+		}
+		llog.Debug("handling form-data", kv)
+		var part *multipart.Part
+		part, err = formPart(r, args.FormKey)
+		if err != nil {
+			kv["key"] = args.FormKey
+			kv["error"] = err
+			llog.Warn("error getting the form part", kv)
+			return 0, dhttp.NewError(http.StatusBadRequest, "error reading form key: %s", err.Error())
+		}
+		//todo: calculate length
+		body = part
+		ct = part.Header.Get("Content-Type")
+		name = part.FileName()
+	case "application/data-url":
+		du, err := dataurl.Decode(body)
+		if err != nil {
+			kv["error"] = err
+			llog.Warn("error reading data-uri", kv)
+			return 0, dhttp.NewError(http.StatusBadRequest, "error reading data-uri: %s", err.Error())
+		}
+		ct = du.ContentType()
+		cl = int64(len(du.Data))
+		body = ioutil.NopCloser(bytes.NewReader(du.Data))
+	}
+
+	a := &types.Assignment{
+		Signature: args.Signature,
+		Filename:  args.Filename,
+		Owner:     args.Owner,
+	}
+
+	if args.LastModified == "" {
+		args.LastModified = strconv.FormatInt(time.Now().Unix(), 10)
+	}
+	extra := map[string]string{
+		"ts": args.LastModified,
+	}
+	res, err := s.uploader.Upload(a, r.Method, body, cl, ct, name, extra)
+	if err != nil {
+		kv["error"] = err
+		llog.Warn("error uploading file", kv)
+		return 0, err
+	}
+
+	js, err := json.Marshal(&uploadRes{
+		Filename:    args.Filename,
+		ContentType: res.ContentType,
+		SHA256:      res.SHA256,
+		MD5:         res.MD5,
+	})
+	if err != nil {
+		kv["error"] = err
+		llog.Warn("error running json.Marshal for upload result", kv)
+		// do not return the error to the client
+		return http.StatusInternalServerError, nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+	return 0, err
+}
+
+// since mapstructure doesn't support embedded structs, copying these here from
+// upload.Assignment
+type chunkArgs struct {
+	Signature    string `json:"sig" mapstructure:"sig"  validate:"nonzero"`
+	Filename     string `json:"filename"  mapstructure:"filename" validate:"nonzero"`
+	Owner        string `json:"owner" mapstructure:"owner"`
+	OffsetStr    string `json:"offset" mapstructure:"offset" validate:"regexp=^[0-9]*$"`
+	Final        string `json:"final" mapstructure:"final"`
+	ContentType  string `json:"contentType" mapstructure:"content_type"`
+	Name         string `json:"name" mapstructure:"name"`
+	LastModified string `json:"lastModified" mapstructure:"last_modified"`
+}
+
+type chunkRes struct {
+	Filename string `json:"filename"`
+	Offset   int64  `json:"offset"`
+}
+
+// offsetHeader holds the number of bytes received in responses from
+// /upload/chunk
+const offsetHeader = "Upload-Offset"
+
+func (s *Server) chunkHandler(w http.ResponseWriter, r *http.Request, args *chunkArgs) (int, error) {
+	kv := rpcutil.RequestKV(r)
+	kv["filename"] = args.Filename
+	kv["method"] = r.Method
+	kv["offset"] = args.OffsetStr
+	llog.Debug("received request to upload chunk", kv)
+
+	a := &types.Assignment{
+		Signature: args.Signature,
+		Filename:  args.Filename,
+		Owner:     args.Owner,
+	}
+
+	if r.Method == "HEAD" {
+		offset, err := s.uploader.Offset(a)
+		if err != nil {
+			return 0, err
+		}
+		w.Header().Set(offsetHeader, strconv.FormatInt(offset, 10))
+		return 0, nil
+	}
+
+	offset, _ := strconv.ParseInt(args.OffsetStr, 10, 64)
+	offset, err := s.uploader.Chunk(a, r.Method, offset, r.Body)
+	w.Header().Set(offsetHeader, strconv.FormatInt(offset, 10))
+	if err != nil {
+		kv["error"] = err
+		llog.Warn("error uploading chunk", kv)
+		return 0, err
+	}
+
+	var res interface{} = &chunkRes{
+		Filename: args.Filename,
+		Offset:   offset,
+	}
+	if args.Final == "1" || args.Final == "true" {
+		if args.LastModified == "" {
+			args.LastModified = strconv.FormatInt(time.Now().Unix(), 10)
+		}
+		extra := map[string]string{
+			"ts": args.LastModified,
+		}
+		ures, err := s.uploader.Commit(a, r.Method, args.ContentType, args.Name, extra)
+		if err != nil {
+			kv["error"] = err
+			llog.Warn("error committing chunked upload", kv)
+			return 0, err
+		}
+		res = &uploadRes{
+			Filename:    args.Filename,
+			ContentType: ures.ContentType,
+			SHA256:      ures.SHA256,
+			MD5:         ures.MD5,
+		}
+	}
+
+	js, err := json.Marshal(res)
+	if err != nil {
+		kv["error"] = err
+		llog.Warn("error running json.Marshal for chunk result", kv)
+		// do not return the error to the client
+		return http.StatusInternalServerError, nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+	return 0, nil
+}
+
+// formPart reads through the multipart form until it finds the part with the
+// given name. Unlike FormFile this streams the part instead of reading the
+// whole form into memory or temporary files first
+func formPart(r *http.Request, key string) (*multipart.Part, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, http.ErrMissingFile
+		} else if err != nil {
+			return nil, err
+		}
+		if p.FormName() == key {
+			return p, nil
+		}
+	}
+}
+
+// since mapstructure doesn't support embedded structs, copying these here from
+// upload.Assignment
+type verifyArgs struct {
+	Signature string `json:"sig" mapstructure:"sig"  validate:"nonzero"`
+	Filename  string `json:"filename"  mapstructure:"filename" validate:"nonzero"`
+	Owner     string `json:"owner" mapstructure:"owner"`
+	CheckUsed string `json:"checkUsed" mapstructure:"check_used"`
+}
+
+func (s *Server) verifyHandler(w http.ResponseWriter, r *http.Request, args *verifyArgs) (int, error) {
+	kv := rpcutil.RequestKV(r)
+	kv["filename"] = args.Filename
+	llog.Debug("received request to verify", kv)
+
+	a := &types.Assignment{
+		Signature: args.Signature,
+		Filename:  args.Filename,
+		Owner:     args.Owner,
+	}
+	err := s.uploader.Verify(a)
+	if err != nil || args.CheckUsed == "" {
+		return 0, err
+	}
+
+	used, err := s.uploader.Used(a)
+	if err != nil {
+		kv["error"] = err
+		llog.Warn("error checking if signature was used", kv)
+		return 0, err
+	}
+	if used {
+		return 0, dhttp.NewError(http.StatusConflict, "signature already used")
+	}
+	return 0, nil
+}
+
+type statArgs struct {
+	Filename  string `json:"filename" mapstructure:"filename" validate:"nonzero"`
+	Signature string `json:"sig" mapstructure:"sig"`
+}
+
+func (s *Server) statHandler(w http.ResponseWriter, r *http.Request, args *statArgs) (int, error) {
+	kv := rpcutil.RequestKV(r)
+	kv["filename"] = args.Filename
+	llog.Debug("received request to stat", kv)
+
+	// the record says who uploaded the file so it's as private as the file
+	if s.download.Private(args.Filename) {
+		err := s.download.Verify(args.Filename, args.Signature, rpcutil.RequestIP(r))
+		if err != nil {
+			kv["error"] = err
+			llog.Info("invalid signature for private file stat", kv)
+			return 0, dhttp.NewError(http.StatusForbidden, "valid signature required for private file")
+		}
+	}
+
+	rec, err := s.uploader.Stat(args.Filename)
+	if err != nil {
+		return 0, err
+	}
+	js, err := json.Marshal(rec)
+	if err != nil {
+		kv["error"] = err
+		llog.Warn("error running json.Marshal for stat result", kv)
+		// do not return the error to the client
+		return http.StatusInternalServerError, nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+	return 0, nil
+}
+
+type deleteArgs struct {
+	Signature string `json:"sig" mapstructure:"sig"`
+	Filename  string `json:"filename"  mapstructure:"filename"`
+	Owner     string `json:"owner" mapstructure:"owner"`
+}
+
+func (s *Server) deleteHandler(w http.ResponseWriter, r *http.Request, args *deleteArgs) (int, error) {
+	kv := rpcutil.RequestKV(r)
+	kv["filename"] = args.Filename
+	llog.Debug("received request to delete", kv)
+
+	if args.Filename == "" {
+		return 404, nil
+	}
+
+	if args.Signature != "" {
+		err := s.uploader.Verify(&types.Assignment{
+			Signature: args.Signature,
+			Filename:  args.Filename,
+			Owner:     args.Owner,
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err := s.uploader.Delete(args.Filename)
+	if err != nil {
+		kv["error"] = err
+		llog.Warn("error deleting file", kv)
+	}
+	return 0, err
+}
+
+func (s *Server) deletePathHandler(w http.ResponseWriter, r *http.Request, args *deleteArgs) (int, error) {
+	if args.Filename == "" {
+		p := strings.Split(r.URL.Path, "/")
+		if len(p) < 3 || p[2] == "" {
+			return 404, nil
+		}
+		args.Filename = p[2]
+	}
+	return s.deleteHandler(w, r, args)
+}
+
+type healthArgs struct{}
+
+// healthzHandler only says that the process is up and serving requests
+func (s *Server) healthzHandler(w http.ResponseWriter, r *http.Request, args *healthArgs) (int, error) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+	return 0, nil
+}
+
+// readyzHandler says whether the backend, usually seaweed, can be reached, so
+// instances that can't reach it can be taken out of rotation
+func (s *Server) readyzHandler(w http.ResponseWriter, r *http.Request, args *healthArgs) (int, error) {
+	if err := s.backend.Ready(); err != nil {
+		kv := rpcutil.RequestKV(r)
+		kv["error"] = err
+		llog.Warn("not ready, backend unreachable", kv)
+		return 0, dhttp.NewError(http.StatusServiceUnavailable, "backend unreachable")
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+	return 0, nil
+}
@@ -0,0 +1,117 @@
+// Package dank provides dank's HTTP server as an http.Handler so dank can be
+// mounted inside another Go program instead of being run as its own process.
+// The dank binary is a thin wrapper around it
+package dank
+
+import (
+	"errors"
+	"github.com/levenlabs/dank/backend"
+	"github.com/levenlabs/dank/config"
+	"github.com/levenlabs/dank/download"
+	dhttp "github.com/levenlabs/dank/http"
+	"github.com/levenlabs/dank/metrics"
+	"github.com/levenlabs/dank/upload"
+	"net/http"
+	"strings"
+)
+
+// Options are used to create a Server
+type Options struct {
+	// Config holds the settings used by the Server and must have a Keyring.
+	// Use config.New to get one with the defaults
+	Config *config.Config
+
+	// Prefix is the path every route is under, like /media to serve files at
+	// /media/get/<filename>. Empty means the routes are at the root
+	Prefix string
+
+	// Backend is where files are stored. If nil the one chosen by the Backend
+	// field of Config is used
+	Backend backend.Backend
+}
+
+// Server is an http.Handler serving all of dank's routes, /get, /sign-get,
+// /assign, /upload, /upload/chunk, /verify, /stat, /delete, /metrics, /healthz
+// and /readyz, under its prefix
+type Server struct {
+	cfg      *config.Config
+	prefix   string
+	backend  backend.Backend
+	uploader *upload.Uploader
+	download *download.Signer
+	mux      *http.ServeMux
+	handler  http.Handler
+}
+
+// NewServer returns a Server using opts. An error is returned if the Config
+// isn't valid or the backend or stores in it can't be opened
+func NewServer(opts Options) (*Server, error) {
+	cfg := opts.Config
+	if cfg == nil {
+		return nil, errors.New("a config is required")
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	b := opts.Backend
+	if b == nil {
+		var err error
+		if b, err = backend.New(cfg); err != nil {
+			return nil, err
+		}
+	}
+	u, err := upload.NewUploader(cfg, b)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		cfg:      cfg,
+		prefix:   cleanPrefix(opts.Prefix),
+		backend:  b,
+		uploader: u,
+		download: download.NewSigner(cfg),
+		mux:      http.NewServeMux(),
+	}
+	// /get/ is needed to handle the filenames in the path
+	s.handle("/get/", s.getPathHandler, "GET", "HEAD")
+	s.handle("/get", s.getHandler, "GET")
+	s.handle("/sign-get", s.signGetHandler, "GET")
+	s.handle("/assign", s.assignHandler, "GET")
+	s.handle("/upload", s.uploadHandler, "POST", "PUT")
+	s.handle("/upload/chunk", s.chunkHandler, "POST", "PUT", "HEAD")
+	s.handle("/verify", s.verifyHandler, "GET")
+	s.handle("/stat", s.statHandler, "GET")
+	s.handle("/delete", s.deleteHandler, "POST")
+	s.handle("/delete/", s.deletePathHandler, "DELETE")
+	s.mux.Handle("/metrics", metrics.Handler())
+	s.handle("/healthz", s.healthzHandler, "GET", "HEAD")
+	s.handle("/readyz", s.readyzHandler, "GET", "HEAD")
+
+	// the routes, and the paths the handlers and CORS see, don't include the
+	// prefix
+	s.handler = s.mux
+	if s.prefix != "" {
+		s.handler = http.StripPrefix(s.prefix, s.mux)
+	}
+	return s, nil
+}
+
+// cleanPrefix returns the prefix with a leading slash and without a trailing
+// one, or an empty string for the root
+func cleanPrefix(prefix string) string {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return ""
+	}
+	return "/" + prefix
+}
+
+// handle registers the handler f for path using dhttp.WrapHandler
+func (s *Server) handle(path string, f interface{}, methods ...string) {
+	s.mux.HandleFunc(path, dhttp.WrapHandler(s.cfg, f, methods...))
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
@@ -0,0 +1,264 @@
+package dank
+
+import (
+	. "testing"
+
+	"bytes"
+	"encoding/json"
+	"github.com/levenlabs/dank/config"
+	"github.com/levenlabs/dank/seaweed/seaweedtest"
+	"github.com/levenlabs/dank/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+)
+
+func testDank(t *T, prefix string) (*httptest.Server, func()) {
+	sw := seaweedtest.NewServer()
+	cfg := config.New()
+	cfg.SeaweedAddr = sw.Addr()
+	cfg.Keyring = []config.Key{{ID: "0", Secret: "test"}}
+	s, err := NewServer(Options{Config: cfg, Prefix: prefix})
+	require.Nil(t, err)
+	srv := httptest.NewServer(s)
+	return srv, func() {
+		srv.Close()
+		sw.Close()
+	}
+}
+
+func TestHandlers(t *T) {
+	srv, done := testDank(t, "")
+	defer done()
+
+	resp, err := http.Get(srv.URL + "/assign?max_size=100")
+	require.Nil(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	a := &types.Assignment{}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(a))
+	resp.Body.Close()
+
+	q := url.Values{"sig": {a.Signature}, "filename": {a.Filename}}
+	resp, err = http.Post(srv.URL+"/upload?"+q.Encode(), "text/plain", bytes.NewBufferString("hello"))
+	require.Nil(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// each signature can only be used once
+	resp, err = http.Post(srv.URL+"/upload?"+q.Encode(), "text/plain", bytes.NewBufferString("bye"))
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	// even if the nonce is written differently
+	parts := strings.Split(a.Signature, "$")
+	parts[3] = parts[3][:4] + "\n" + parts[3][4:]
+	q2 := url.Values{"sig": {strings.Join(parts, "$")}, "filename": {a.Filename}}
+	resp, err = http.Post(srv.URL+"/upload?"+q2.Encode(), "text/plain", bytes.NewBufferString("bye"))
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	resp, err = http.Get(srv.URL + "/get/" + a.Filename)
+	require.Nil(t, err)
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "hello", string(b))
+	assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+
+	req, err := http.NewRequest("GET", srv.URL+"/get/"+a.Filename, nil)
+	require.Nil(t, err)
+	req.Header.Set("Range", "bytes=1-3")
+	resp, err = http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	b, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, "ell", string(b))
+	assert.Equal(t, "bytes 1-3/5", resp.Header.Get("Content-Range"))
+
+	resp, err = http.Get(srv.URL + "/verify?" + q.Encode())
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	req, err = http.NewRequest("DELETE", srv.URL+"/delete/"+a.Filename, nil)
+	require.Nil(t, err)
+	resp, err = http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = http.Get(srv.URL + "/get/" + a.Filename)
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestUploadTooLarge(t *T) {
+	srv, done := testDank(t, "")
+	defer done()
+
+	resp, err := http.Get(srv.URL + "/assign?max_size=3")
+	require.Nil(t, err)
+	a := &types.Assignment{}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(a))
+	resp.Body.Close()
+
+	q := url.Values{"sig": {a.Signature}, "filename": {a.Filename}}
+	resp, err = http.Post(srv.URL+"/upload?"+q.Encode(), "text/plain", bytes.NewBufferString("hello"))
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+}
+
+func TestHealth(t *T) {
+	srv, done := testDank(t, "")
+	defer done()
+
+	for _, p := range []string{"/healthz", "/readyz"} {
+		resp, err := http.Get(srv.URL + p)
+		require.Nil(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode, p)
+	}
+}
+
+func TestPrefix(t *T) {
+	srv, done := testDank(t, "/media/")
+	defer done()
+
+	resp, err := http.Get(srv.URL + "/assign")
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, err = http.Get(srv.URL + "/media/assign")
+	require.Nil(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	a := &types.Assignment{}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(a))
+	resp.Body.Close()
+
+	q := url.Values{"sig": {a.Signature}, "filename": {a.Filename}}
+	resp, err = http.Post(srv.URL+"/media/upload?"+q.Encode(), "text/plain", bytes.NewBufferString("hello"))
+	require.Nil(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = http.Get(srv.URL + "/media/sign-get?" + url.Values{"filename": {a.Filename}}.Encode())
+	require.Nil(t, err)
+	sg := &signGetRes{}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(sg))
+	resp.Body.Close()
+	assert.True(t, strings.HasPrefix(sg.URL, "/media/get/"+a.Filename+"?"))
+
+	resp, err = http.Get(srv.URL + sg.URL)
+	require.Nil(t, err)
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "hello", string(b))
+
+	req, err := http.NewRequest("DELETE", srv.URL+"/media/delete/"+a.Filename, nil)
+	require.Nil(t, err)
+	resp, err = http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestNewServerInvalid(t *T) {
+	_, err := NewServer(Options{})
+	assert.NotNil(t, err)
+	// there's no secret
+	_, err = NewServer(Options{Config: config.New()})
+	assert.NotNil(t, err)
+}
+
+// headerCounter counts the calls to WriteHeader
+type headerCounter struct {
+	*httptest.ResponseRecorder
+	n int
+}
+
+func (h *headerCounter) WriteHeader(code int) {
+	h.n++
+	h.ResponseRecorder.WriteHeader(code)
+}
+
+func TestGetWriteHeaderOnce(t *T) {
+	sw := seaweedtest.NewServer()
+	defer sw.Close()
+	cfg := config.New()
+	cfg.SeaweedAddr = sw.Addr()
+	cfg.Keyring = []config.Key{{ID: "0", Secret: "test"}}
+	s, err := NewServer(Options{Config: cfg})
+	require.Nil(t, err)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/assign?max_size=100", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	a := &types.Assignment{}
+	require.Nil(t, json.NewDecoder(w.Body).Decode(a))
+	q := url.Values{"sig": {a.Signature}, "filename": {a.Filename}}
+	r := httptest.NewRequest("POST", "/upload?"+q.Encode(), bytes.NewBufferString("hello"))
+	r.Header.Set("Content-Type", "text/plain")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	for _, rng := range []string{"", "bytes=1-3"} {
+		r = httptest.NewRequest("GET", "/get/"+a.Filename, nil)
+		if rng != "" {
+			r.Header.Set("Range", rng)
+		}
+		hc := &headerCounter{ResponseRecorder: httptest.NewRecorder()}
+		s.ServeHTTP(hc, r)
+		assert.Equal(t, 1, hc.n, rng)
+	}
+}
+
+func TestStatPrivate(t *T) {
+	srv, done := testDank(t, "")
+	defer done()
+
+	resp, err := http.Get(srv.URL + "/assign?private=1")
+	require.Nil(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	a := &types.Assignment{}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(a))
+	resp.Body.Close()
+	q := url.Values{"sig": {a.Signature}, "filename": {a.Filename}}
+	resp, err = http.Post(srv.URL+"/upload?"+q.Encode(), "text/plain", bytes.NewBufferString("hello"))
+	require.Nil(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// the record of a private file needs a signature like the file does
+	resp, err = http.Get(srv.URL + "/stat?filename=" + url.QueryEscape(a.Filename))
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, err = http.Get(srv.URL + "/sign-get?filename=" + url.QueryEscape(a.Filename))
+	require.Nil(t, err)
+	sg := struct {
+		Sig string `json:"sig"`
+	}{}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&sg))
+	resp.Body.Close()
+	q = url.Values{"sig": {sg.Sig}, "filename": {a.Filename}}
+	resp, err = http.Get(srv.URL + "/stat?" + q.Encode())
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
@@ -0,0 +1,119 @@
+package download
+
+import (
+	. "testing"
+
+	"encoding/base64"
+	"github.com/levenlabs/dank/config"
+	"github.com/levenlabs/dank/seaweed"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"time"
+)
+
+var testKeyring = []config.Key{{ID: "0", Secret: "test"}}
+
+func testSigner(keyring []config.Key) *Signer {
+	cfg := config.New()
+	cfg.Keyring = keyring
+	return NewSigner(cfg)
+}
+
+func testFilename(fid string) string {
+	return base64.URLEncoding.EncodeToString([]byte(fid)) + ".jpg"
+}
+
+func TestPrivate(t *T) {
+	s := testSigner(testKeyring)
+	f := testFilename("3,01637037d6")
+	assert.False(t, s.Private(f))
+
+	pf, err := s.PrivateFilename(f)
+	require.Nil(t, err)
+	assert.True(t, strings.HasSuffix(pf, ".jpg"))
+	assert.True(t, s.Private(pf))
+
+	ar, err := seaweed.NewAssignResult("", pf)
+	require.Nil(t, err)
+	assert.True(t, strings.HasPrefix(ar.FID(), "3,01"))
+	assert.NotEqual(t, "3,01637037d6", ar.FID())
+
+	// seaweed adds the delta to the key, so a file with a delta is private
+	// however its needle is named
+	pf2, err := s.PrivateFilename(testFilename("3,01637037d6_1"))
+	require.Nil(t, err)
+	assert.True(t, s.Private(pf2))
+	ar2, err := seaweed.NewAssignResult("", pf2)
+	require.Nil(t, err)
+	fid2 := ar2.FID()
+	assert.True(t, strings.HasPrefix(fid2, "3,01"))
+	assert.True(t, strings.HasSuffix(fid2, "_1"))
+	cookie2 := fid2[len(fid2)-2-cookieLen : len(fid2)-2]
+	assert.True(t, s.Private(testFilename("3,02"+cookie2)))
+	pf3, err := s.PrivateFilename(testFilename("3,0101637037d6_0"))
+	require.Nil(t, err)
+	ar3, err := seaweed.NewAssignResult("", pf3)
+	require.Nil(t, err)
+	cookie3 := ar3.FID()[len(ar3.FID())-2-cookieLen : len(ar3.FID())-2]
+	assert.True(t, s.Private(testFilename("3,0101"+cookie3)))
+	assert.True(t, s.Private(testFilename("3,01"+cookie3+"_256")))
+	// the cookie of one needle doesn't make another private
+	cookie := ar.FID()[len(ar.FID())-cookieLen:]
+	for _, fid := range []string{"3,01" + cookie + "_1", "3,02" + cookie} {
+		assert.False(t, s.Private(testFilename(fid)), fid)
+	}
+
+	// still private after rotating the key
+	rotated := append([]config.Key{{ID: "new", Secret: "new"}}, testKeyring...)
+	assert.True(t, testSigner(rotated).Private(pf))
+	assert.False(t, testSigner(rotated[:1]).Private(pf))
+
+	// other ways of writing the same fid reach the same file in seaweed so
+	// they're treated as private and can't be made private
+	pfid := ar.FID()
+	for _, fid := range []string{
+		"03" + pfid[1:],
+		strings.ToUpper(pfid),
+		"3,00" + pfid[2:],
+		pfid + "_01",
+	} {
+		assert.True(t, s.Private(testFilename(fid)), fid)
+		_, err = s.PrivateFilename(testFilename(fid))
+		assert.NotNil(t, err, fid)
+	}
+	for _, fid := range []string{"03,01637037d6", "3,01637037D6", "3,1637037d6"} {
+		_, err = s.PrivateFilename(testFilename(fid))
+		assert.NotNil(t, err, fid)
+	}
+
+	_, err = s.PrivateFilename(testFilename("3,0163"))
+	assert.NotNil(t, err)
+	assert.False(t, s.Private("!!!"))
+}
+
+func TestSignVerify(t *T) {
+	s := testSigner(testKeyring)
+	f := testFilename("3,01637037d6")
+	expires := time.Now().Add(time.Minute).Unix()
+
+	sig, err := s.Sign(f, expires, "")
+	require.Nil(t, err)
+	assert.Nil(t, s.Verify(f, sig, ""))
+	assert.Nil(t, s.Verify(f, sig, "10.0.0.1"))
+	// the extension doesn't matter
+	assert.Nil(t, s.Verify(strings.TrimSuffix(f, ".jpg")+".png", sig, ""))
+	assert.NotNil(t, s.Verify(testFilename("3,01637037d7"), sig, ""))
+	assert.NotNil(t, s.Verify(f, "", ""))
+	assert.NotNil(t, s.Verify(f, strings.Replace(sig, "$0$", "$1$", 1), ""))
+
+	sig, err = s.Sign(f, expires, "10.0.0.1")
+	require.Nil(t, err)
+	assert.Nil(t, s.Verify(f, sig, "10.0.0.1"))
+	assert.NotNil(t, s.Verify(f, sig, "10.0.0.2"))
+	assert.NotNil(t, s.Verify(f, sig, ""))
+
+	sig, err = s.Sign(f, time.Now().Add(-time.Second).Unix(), "")
+	require.Nil(t, err)
+	assert.Equal(t, errExpired, s.Verify(f, sig, ""))
+}
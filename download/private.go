@@ -0,0 +1,162 @@
+// Package download handles private files and the signed URLs needed to
+// download them.
+//
+// A file is marked private by replacing the cookie seaweed gave its fid with
+// one derived from the secret. Seaweed stores whatever cookie a file is first
+// uploaded with, and refuses to return it with any other, so the mark can't be
+// removed by someone who only knows the filename and no state has to be kept
+// about which files are private
+package download
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/levenlabs/dank/config"
+	"github.com/levenlabs/dank/seaweed"
+	"golang.org/x/crypto/hkdf"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// cookieLen is the number of hex characters at the end of a fid that are the
+// cookie
+const cookieLen = 8
+
+var (
+	errInvalidFID      = errors.New("invalid fid")
+	errNonCanonicalFID = errors.New("fid is not formatted like seaweed formats it")
+)
+
+// deriveKey turns a secret into a 256-bit key used only for info
+func deriveKey(k config.Key, info string) ([]byte, error) {
+	key := make([]byte, 32)
+	r := hkdf.New(sha256.New, []byte(k.Secret), nil, []byte(info))
+	if _, err := io.ReadFull(r, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// splitFID splits a fid of the form volume,keycookie_delta into the
+// volume,key part as it's written, the cookie and the delta, including the
+// underscore. It also returns the volume,key of the needle seaweed actually
+// reads, since seaweed adds the delta to the key, which is what the private
+// cookie covers so every way of naming a needle gets the same cookie.
+//
+// Seaweed parses the volume and delta as decimal and the key and cookie as hex,
+// so the same file can be written many ways. Only the way seaweed formats it is
+// accepted, otherwise errNonCanonicalFID is returned
+func splitFID(fid string) (prefix, needle, cookie, delta string, err error) {
+	raw := fid
+	if i := strings.LastIndex(fid, "_"); i > 0 {
+		fid, delta = fid[:i], fid[i:]
+	}
+	i := strings.Index(fid, ",")
+	if i < 1 || len(fid)-i-1 <= cookieLen {
+		return "", "", "", "", errInvalidFID
+	}
+	c := len(fid) - cookieLen
+	vol, err := strconv.ParseUint(fid[:i], 10, 32)
+	if err != nil {
+		return "", "", "", "", errInvalidFID
+	}
+	key, err := strconv.ParseUint(fid[i+1:c], 16, 64)
+	if err != nil {
+		return "", "", "", "", errInvalidFID
+	}
+	ck, err := strconv.ParseUint(fid[c:], 16, 32)
+	if err != nil {
+		return "", "", "", "", errInvalidFID
+	}
+	var d uint64
+	if delta != "" {
+		if d, err = strconv.ParseUint(delta[1:], 10, 32); err != nil {
+			return "", "", "", "", errInvalidFID
+		}
+		delta = "_" + strconv.FormatUint(d, 10)
+	}
+	if key+d < key {
+		return "", "", "", "", errInvalidFID
+	}
+	canonical := seaweed.FormatFID(uint32(vol), key, uint32(ck))
+	if canonical+delta != raw {
+		return "", "", "", "", errNonCanonicalFID
+	}
+	c = len(canonical) - cookieLen
+	needle = seaweed.FormatFID(uint32(vol), key+d, uint32(ck))
+	return canonical[:c], needle[:len(needle)-cookieLen], canonical[c:], delta, nil
+}
+
+// privateCookie returns the cookie that marks the needle with the given
+// volume,key as private
+func privateCookie(k config.Key, volKey string) (string, error) {
+	key, err := deriveKey(k, "dank private v1")
+	if err != nil {
+		return "", err
+	}
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(volKey))
+	return fmt.Sprintf("%x", h.Sum(nil)[:cookieLen/2]), nil
+}
+
+// privateFID returns fid with its cookie replaced by the private cookie
+func (s *Signer) privateFID(fid string) (string, error) {
+	prefix, needle, _, delta, err := splitFID(fid)
+	if err != nil {
+		return "", err
+	}
+	pc, err := privateCookie(s.keyring[0], needle)
+	if err != nil {
+		return "", err
+	}
+	return prefix + pc + delta, nil
+}
+
+// MakePrivate returns an AssignResult for the same seaweed file as ar but
+// marked private. It must be used before anything is uploaded to ar
+func (s *Signer) MakePrivate(ar *seaweed.AssignResult) (*seaweed.AssignResult, error) {
+	fid, err := s.privateFID(ar.FID())
+	if err != nil {
+		return nil, err
+	}
+	return seaweed.NewRawAssignResult(ar.Host(), fid), nil
+}
+
+// PrivateFilename returns filename marked as private
+func (s *Signer) PrivateFilename(filename string) (string, error) {
+	ar, err := seaweed.NewAssignResult("", filename)
+	if err != nil {
+		return "", err
+	}
+	if ar, err = s.MakePrivate(ar); err != nil {
+		return "", err
+	}
+	return ar.Filename() + filepath.Ext(filename), nil
+}
+
+// Private returns true if filename was marked as private by any of the keys in
+// the keyring. A fid that isn't formatted the way seaweed formats it could be
+// another way of writing a private file, so it's also treated as private
+func (s *Signer) Private(filename string) bool {
+	ar, err := seaweed.NewAssignResult("", filename)
+	if err != nil {
+		return false
+	}
+	_, needle, cookie, _, err := splitFID(ar.FID())
+	if err == errNonCanonicalFID {
+		return true
+	} else if err != nil {
+		return false
+	}
+	for _, k := range s.keyring {
+		pc, err := privateCookie(k, needle)
+		if err == nil && hmac.Equal([]byte(pc), []byte(cookie)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package download
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"github.com/levenlabs/dank/config"
+	"github.com/levenlabs/dank/seaweed"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var encoder = base64.RawURLEncoding
+
+var (
+	errInvalidSig = errors.New("invalid signature")
+	errExpired    = errors.New("signature expired")
+)
+
+// Signer marks files as private and signs the urls used to download them with
+// the Keyring of a Config. The first key is used to sign and mark files and
+// the rest are only used to verify
+type Signer struct {
+	keyring []config.Key
+}
+
+// NewSigner returns a Signer using the Keyring of cfg
+func NewSigner(cfg *config.Config) *Signer {
+	return &Signer{keyring: cfg.Keyring}
+}
+
+// mac returns the HMAC of the fid of filename, expires and ip using k
+func mac(k config.Key, filename string, expires int64, ip string) ([]byte, error) {
+	ar, err := seaweed.NewAssignResult("", filename)
+	if err != nil {
+		return nil, err
+	}
+	key, err := deriveKey(k, "dank download v1")
+	if err != nil {
+		return nil, err
+	}
+	h := hmac.New(sha256.New, key)
+	// the fid is used instead of the filename so the extension doesn't matter
+	h.Write([]byte(ar.FID() + "\x00" + strconv.FormatInt(expires, 10) + "\x00" + ip))
+	return h.Sum(nil), nil
+}
+
+// Sign returns a signature that allows filename to be downloaded until the
+// unix time expires. If ip is not empty then only that ip can use it. The
+// signature looks like 1$id$expires$ipBound$mac
+func (s *Signer) Sign(filename string, expires int64, ip string) (string, error) {
+	k := s.keyring[0]
+	m, err := mac(k, filename, expires, ip)
+	if err != nil {
+		return "", err
+	}
+	bound := "0"
+	if ip != "" {
+		bound = "1"
+	}
+	return strings.Join([]string{
+		"1",
+		k.ID,
+		strconv.FormatInt(expires, 10),
+		bound,
+		encoder.EncodeToString(m),
+	}, "$"), nil
+}
+
+// Verify checks that sig was made by Sign for filename, hasn't expired, and if
+// it was bound to an ip, that ip is the one sent
+func (s *Signer) Verify(filename, sig, ip string) error {
+	parts := strings.Split(sig, "$")
+	if len(parts) != 5 || parts[0] != "1" {
+		return errInvalidSig
+	}
+	var k config.Key
+	var found bool
+	for _, kk := range s.keyring {
+		if kk.ID == parts[1] {
+			k, found = kk, true
+			break
+		}
+	}
+	if !found {
+		return errInvalidSig
+	}
+	expires, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return errInvalidSig
+	}
+	if parts[3] == "0" {
+		ip = ""
+	} else if parts[3] != "1" {
+		return errInvalidSig
+	}
+	got, err := encoder.DecodeString(parts[4])
+	if err != nil {
+		return errInvalidSig
+	}
+	want, err := mac(k, filename, expires, ip)
+	if err != nil || !hmac.Equal(got, want) {
+		return errInvalidSig
+	}
+	if time.Now().Unix() > expires {
+		return errExpired
+	}
+	return nil
+}
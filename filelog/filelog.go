@@ -0,0 +1,186 @@
+// Package filelog implements the append-only file that the file backed stores,
+// like replay.FileStore and meta.FileStore, use so their contents survive
+// restarts. Every change to a store is appended as a line and the file is
+// periodically compacted by rewriting it with only the store's current
+// contents
+package filelog
+
+import (
+	"bufio"
+	"errors"
+	"github.com/levenlabs/go-llog"
+	"io"
+	"os"
+	"sync"
+)
+
+// DefaultCompactEvery is the number of lines appended to a Log before it's
+// compacted, unless CompactEvery is changed
+const DefaultCompactEvery = 10000
+
+// MaxLineLen is the longest line, not including the newline, that can be
+// appended to a Log. Longer lines are skipped when a Log is opened
+const MaxLineLen = 1024 * 1024
+
+// ErrLineTooLong is returned from Append when the line is longer than
+// MaxLineLen
+var ErrLineTooLong = errors.New("filelog: line too long")
+
+// Log is an append-only file of lines. It's safe for concurrent use
+type Log struct {
+	// CompactEvery is how many lines are appended between compactions
+	CompactEvery int
+
+	l        sync.Mutex
+	f        *os.File
+	path     string
+	snapshot func(write func(line string) error) error
+	appended int
+}
+
+// Open reads the file at path, if it exists, calling load with each line, in
+// order and without the trailing newline. It then compacts the file and opens
+// it for appending. snapshot is called whenever the file is compacted and must
+// call write with the lines, each ending in a newline, that recreate the
+// store's current contents
+func Open(path string, load func(line string) error, snapshot func(write func(line string) error) error) (*Log, error) {
+	lg := &Log{
+		CompactEvery: DefaultCompactEvery,
+		path:         path,
+		snapshot:     snapshot,
+	}
+	if err := lg.load(load); err != nil {
+		return nil, err
+	}
+	if err := lg.compact(); err != nil {
+		return nil, err
+	}
+	return lg, nil
+}
+
+func (lg *Log) load(fn func(string) error) error {
+	f, err := os.Open(lg.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var line []byte
+	var tooLong bool
+	for {
+		chunk, isPrefix, err := r.ReadLine()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if len(line)+len(chunk) > MaxLineLen {
+			tooLong = true
+		} else if !tooLong {
+			line = append(line, chunk...)
+		}
+		if isPrefix {
+			continue
+		}
+		if tooLong {
+			// Append won't write these, but skip any from before that so
+			// one bad line doesn't stop the store from opening
+			llog.Warn("skipping line that's too long", llog.KV{"path": lg.path})
+		} else if err := fn(string(line)); err != nil {
+			return err
+		}
+		line, tooLong = line[:0], false
+	}
+}
+
+// compact writes out the snapshot to a new file, replaces the old file with it
+// and then opens it for appending. It must be called with the lock held, or
+// before the Log is returned from Open
+func (lg *Log) compact() error {
+	tmp := lg.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	err = lg.snapshot(func(line string) error {
+		_, err := w.WriteString(line)
+		return err
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err = os.Rename(tmp, lg.path); err != nil {
+		return err
+	}
+
+	nf, err := os.OpenFile(lg.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if lg.f != nil {
+		lg.f.Close()
+	}
+	lg.f = nf
+	lg.appended = 0
+	return nil
+}
+
+// Append calls apply to change the store and then appends line, which must end
+// in a newline, to the file. ErrLineTooLong is returned, without calling apply,
+// if the line is longer than MaxLineLen. If the line can't be written, undo, if
+// it's not nil, is called to revert the change. The Log is locked throughout so
+// a compaction can't happen between the change and its line
+func (lg *Log) Append(line string, apply func() error, undo func()) error {
+	if len(line) > MaxLineLen+1 {
+		return ErrLineTooLong
+	}
+	lg.l.Lock()
+	defer lg.l.Unlock()
+	if err := apply(); err != nil {
+		return err
+	}
+	_, err := lg.f.WriteString(line)
+	if err == nil {
+		err = lg.f.Sync()
+	}
+	if err != nil {
+		if undo != nil {
+			undo()
+		}
+		return err
+	}
+
+	if lg.appended++; lg.appended >= lg.CompactEvery {
+		// the line was written so the change isn't failed if this fails, and
+		// it's tried again after another CompactEvery lines
+		if err := lg.compact(); err != nil {
+			lg.appended = 0
+			llog.Warn("error compacting file", llog.KV{
+				"path":  lg.path,
+				"error": err,
+			})
+		}
+	}
+	return nil
+}
+
+// Close closes the underlying file. The Log cannot be used afterwards
+func (lg *Log) Close() error {
+	lg.l.Lock()
+	defer lg.l.Unlock()
+	return lg.f.Close()
+}
@@ -0,0 +1,105 @@
+package filelog
+
+import (
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// testSet is a set of strings where each line is "+ <s>" or "- <s>"
+type testSet map[string]bool
+
+func (ts testSet) load(line string) error {
+	if strings.HasPrefix(line, "+ ") {
+		ts[line[2:]] = true
+	} else if strings.HasPrefix(line, "- ") {
+		delete(ts, line[2:])
+	}
+	return nil
+}
+
+func (ts testSet) snapshot(write func(string) error) error {
+	for s := range ts {
+		if err := write("+ " + s + "\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ts testSet) add(lg *Log, s string) error {
+	return lg.Append("+ "+s+"\n", func() error {
+		ts[s] = true
+		return nil
+	}, func() {
+		delete(ts, s)
+	})
+}
+
+func (ts testSet) remove(lg *Log, s string) error {
+	return lg.Append("- "+s+"\n", func() error {
+		delete(ts, s)
+		return nil
+	}, nil)
+}
+
+func lines(t *T, path string) []string {
+	b, err := ioutil.ReadFile(path)
+	require.Nil(t, err)
+	return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+}
+
+func TestLog(t *T) {
+	dir, err := ioutil.TempDir("", "dank-filelog")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "log")
+
+	ts := testSet{}
+	lg, err := Open(path, ts.load, ts.snapshot)
+	require.Nil(t, err)
+	lg.CompactEvery = 4
+	require.Nil(t, ts.add(lg, "a"))
+	require.Nil(t, ts.add(lg, "b"))
+	require.Nil(t, ts.remove(lg, "a"))
+	assert.Equal(t, []string{"+ a", "+ b", "- a"}, lines(t, path))
+
+	// the fourth line compacts the file down to what's left
+	require.Nil(t, ts.add(lg, "c"))
+	assert.Len(t, lines(t, path), 2)
+	require.Nil(t, ts.remove(lg, "c"))
+	assert.Len(t, lines(t, path), 3)
+	require.Nil(t, lg.Close())
+
+	ts2 := testSet{}
+	lg, err = Open(path, ts2.load, ts2.snapshot)
+	require.Nil(t, err)
+	defer lg.Close()
+	assert.Equal(t, testSet{"b": true}, ts2)
+	assert.Equal(t, []string{"+ b"}, lines(t, path))
+}
+
+func TestLogLongLine(t *T) {
+	dir, err := ioutil.TempDir("", "dank-filelog")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "log")
+
+	// a line that's too long, like one written before there was a limit,
+	// is skipped instead of failing the open
+	long := "+ " + strings.Repeat("x", MaxLineLen)
+	require.Nil(t, ioutil.WriteFile(path, []byte("+ a\n"+long+"\n+ b\n"+long), 0600))
+	ts := testSet{}
+	lg, err := Open(path, ts.load, ts.snapshot)
+	require.Nil(t, err)
+	defer lg.Close()
+	assert.Equal(t, testSet{"a": true, "b": true}, ts)
+
+	assert.Equal(t, ErrLineTooLong, ts.add(lg, strings.Repeat("x", MaxLineLen)))
+	assert.Equal(t, testSet{"a": true, "b": true}, ts)
+}
@@ -0,0 +1,96 @@
+package http
+
+import (
+	"github.com/levenlabs/dank/config"
+	. "net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsExposeHeaders are the response headers, besides the ones browsers
+// always allow, that CORS requests can read
+var corsExposeHeaders = []string{
+	"Upload-Offset",
+	"Content-Disposition",
+}
+
+// corsEndpoint determines if CORS is enabled for the path
+func corsEndpoint(cfg *config.Config, path string) bool {
+	if len(cfg.CORSEndpoints) == 0 {
+		return true
+	}
+	for _, e := range cfg.CORSEndpoints {
+		if path == e || strings.HasPrefix(path, strings.TrimSuffix(e, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// corsOrigin returns the value to send as Access-Control-Allow-Origin or an
+// empty string if the request isn't an allowed CORS request
+func corsOrigin(cfg *config.Config, r *Request) string {
+	origin := r.Header.Get("Origin")
+	if cfg == nil || origin == "" || !corsEndpoint(cfg, r.URL.Path) {
+		return ""
+	}
+	for _, o := range cfg.CORSOrigins {
+		if o == "*" {
+			return "*"
+		} else if strings.EqualFold(o, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// corsMethods returns the methods, out of the ones the endpoint accepts, that
+// the CORS settings of cfg allow
+func corsMethods(cfg *config.Config, methods []string) []string {
+	if len(cfg.CORSMethods) == 0 {
+		return methods
+	}
+	var allowed []string
+	for _, m := range methods {
+		for _, cm := range cfg.CORSMethods {
+			if m == cm {
+				allowed = append(allowed, m)
+				break
+			}
+		}
+	}
+	return allowed
+}
+
+// handleCORS sets the CORS headers on w if r is a CORS request allowed by the
+// CORS settings of cfg. A nil cfg means CORS is disabled. methods are the
+// methods the endpoint accepts. It returns true if r was a preflight request,
+// which it has already responded to
+func handleCORS(cfg *config.Config, w ResponseWriter, r *Request, methods []string) bool {
+	origin := corsOrigin(cfg, r)
+	if origin == "" {
+		return false
+	}
+	h := w.Header()
+	h.Set("Access-Control-Allow-Origin", origin)
+	if origin != "*" {
+		h.Add("Vary", "Origin")
+	}
+	if r.Method != "OPTIONS" || r.Header.Get("Access-Control-Request-Method") == "" {
+		h.Set("Access-Control-Expose-Headers", strings.Join(corsExposeHeaders, ", "))
+		return false
+	}
+
+	if methods = corsMethods(cfg, methods); len(methods) > 0 {
+		h.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	}
+	if len(cfg.CORSHeaders) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(cfg.CORSHeaders, ", "))
+	}
+	if cfg.CORSMaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(cfg.CORSMaxAge/time.Second)))
+	}
+	w.WriteHeader(StatusNoContent)
+	return true
+}
@@ -0,0 +1,80 @@
+package http
+
+import (
+	. "testing"
+
+	"github.com/levenlabs/dank/config"
+	"github.com/stretchr/testify/assert"
+	. "net/http"
+	"net/http/httptest"
+	"time"
+)
+
+type corsArgs struct{}
+
+func corsTestHandler(w ResponseWriter, r *Request, args *corsArgs) (int, error) {
+	return 0, nil
+}
+
+func TestCORS(t *T) {
+	cfg := config.New()
+	cfg.CORSOrigins = []string{"https://example.com"}
+	cfg.CORSEndpoints = []string{"/upload", "/get"}
+	cfg.CORSHeaders = []string{"Content-Type"}
+	cfg.CORSMaxAge = 10 * time.Minute
+	h := WrapHandler(cfg, corsTestHandler, "POST", "PUT")
+
+	do := func(method, path, origin string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if method == "OPTIONS" {
+			r.Header.Set("Access-Control-Request-Method", "PUT")
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+
+	w := do("OPTIONS", "/upload", "https://example.com")
+	assert.Equal(t, StatusNoContent, w.Code)
+	assert.Equal(t, "https://example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "POST, PUT", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Content-Type", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+
+	w = do("PUT", "/upload/chunk", "https://example.com")
+	assert.Equal(t, StatusOK, w.Code)
+	assert.Equal(t, "https://example.com", w.Header().Get("Access-Control-Allow-Origin"))
+
+	// not an allowed origin or endpoint
+	w = do("OPTIONS", "/upload", "https://other.com")
+	assert.Equal(t, StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Origin"))
+	w = do("OPTIONS", "/assign", "https://example.com")
+	assert.Equal(t, StatusMethodNotAllowed, w.Code)
+	w = do("PUT", "/uploads", "https://example.com")
+	assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Origin"))
+
+	cfg.CORSOrigins = []string{"*"}
+	cfg.CORSEndpoints = nil
+	w = do("OPTIONS", "/assign", "https://other.com")
+	assert.Equal(t, StatusNoContent, w.Code)
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+
+	// only the methods both allowed and accepted by the endpoint are sent
+	cfg.CORSMethods = []string{"PUT", "DELETE"}
+	w = do("OPTIONS", "/upload", "https://example.com")
+	assert.Equal(t, StatusNoContent, w.Code)
+	assert.Equal(t, "PUT", w.Header().Get("Access-Control-Allow-Methods"))
+	cfg.CORSMethods = []string{"DELETE"}
+	w = do("OPTIONS", "/upload", "https://example.com")
+	assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Methods"))
+
+	// no config means no CORS
+	h = WrapHandler(nil, corsTestHandler, "POST", "PUT")
+	w = do("OPTIONS", "/upload", "https://example.com")
+	assert.Equal(t, StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Origin"))
+}
@@ -8,6 +8,7 @@ import "fmt"
 type HTTPError struct {
 	message    string
 	statusCode int
+	reason     string
 }
 
 func (e HTTPError) Error() string {
@@ -18,6 +19,19 @@ func (e HTTPError) Code() int {
 	return e.statusCode
 }
 
+// Reason returns a short, fixed, description of why the error happened, like
+// "max_size", which is used to group errors in metrics. It's empty unless
+// WithReason was used
+func (e HTTPError) Reason() string {
+	return e.reason
+}
+
+// WithReason returns a copy of the error with the given Reason
+func (e HTTPError) WithReason(reason string) HTTPError {
+	e.reason = reason
+	return e
+}
+
 func NewError(statusCode int, msg string, args ...interface{}) HTTPError {
 	return HTTPError{
 		message:    fmt.Sprintf(msg, args...),
@@ -2,6 +2,8 @@ package http
 
 import (
 	"fmt"
+	"github.com/levenlabs/dank/config"
+	"github.com/levenlabs/dank/metrics"
 	"github.com/levenlabs/go-llog"
 	"github.com/levenlabs/golib/rpcutil"
 	"github.com/mitchellh/mapstructure"
@@ -11,9 +13,10 @@ import (
 	"reflect"
 	"runtime"
 	"strings"
+	"time"
 )
 
-//store the common types needed to wrapHandler
+// store the common types needed to wrapHandler
 var typeOfResponseWriter = reflect.TypeOf((*ResponseWriter)(nil)).Elem()
 var typeOfPtrRequest = reflect.TypeOf((*Request)(nil))
 var typeOfInt = reflect.TypeOf(int(0))
@@ -43,15 +46,17 @@ func FirstQueryVals(m url.Values) map[string]string {
 	return dst
 }
 
-// wrapHandler takes a handler function and for each request, it rejects
-// unaccepted methods, converts the query args to the function's args pointer
-// and then validates those args.
+// wrapHandler takes a handler function and for each request, it responds to
+// CORS preflight requests, rejects unaccepted methods, converts the query args
+// to the function's args pointer and then validates those args. CORS is
+// handled using the CORS settings of cfg, and is disabled if cfg is nil. f can
+// also be a method value
 //
 // If the method returns a non-nil error, then the error is returned if its an
 // instance of PublicError, otherwise a generic "Internal Error" is sent back
 // to the client. If a status code of 0 is returned, then if error is nil, a 500
 // is sent and otherwise a 200 is sent.
-func WrapHandler(f interface{}, methods ...string) func(ResponseWriter, *Request) {
+func WrapHandler(cfg *config.Config, f interface{}, methods ...string) func(ResponseWriter, *Request) {
 	fnVal := reflect.ValueOf(f)
 	if fnVal.Kind() != reflect.Func {
 		panic("http: invalid func passed to wrapHandler")
@@ -81,11 +86,21 @@ func WrapHandler(f interface{}, methods ...string) func(ResponseWriter, *Request
 		panic("http: invalid 2nd return in func passed to wrapHandler")
 	}
 	fnName := runtime.FuncForPC(fnVal.Pointer()).Name()
+	// metrics only use the name without the package, or the receiver and
+	// suffix of a method value
+	shortName := strings.TrimSuffix(fnName[strings.LastIndex(fnName, ".")+1:], "-fm")
 	return func(w ResponseWriter, r *Request) {
+		start := time.Now()
 		kv := rpcutil.RequestKV(r)
 		kv["handler"] = fnName
 		llog.Debug("Received HTTP request", kv)
 
+		if handleCORS(cfg, w, r, methods) {
+			llog.Debug("responded to CORS preflight request", kv)
+			metrics.ObserveRequest(shortName, StatusNoContent, start)
+			return
+		}
+
 		var code int
 		var err error
 		// first check the method
@@ -146,5 +161,10 @@ func WrapHandler(f interface{}, methods ...string) func(ResponseWriter, *Request
 		}
 		kv["code"] = code
 		llog.Debug("responded to HTTP request", kv)
+		if code == 0 {
+			// the handler wrote the response itself
+			code = StatusOK
+		}
+		metrics.ObserveRequest(shortName, code, start)
 	}
 }
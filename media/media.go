@@ -0,0 +1,83 @@
+// Package media sniffs audio and video containers and reads their duration
+// from the container headers without decoding any of the media
+package media
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"time"
+)
+
+// The types of media a container can hold. A container with any video track
+// is Video even if it also has audio
+const (
+	Audio = "audio"
+	Video = "video"
+)
+
+// ErrUnknownFormat is returned from Probe when the data isn't in one of the
+// supported containers
+var ErrUnknownFormat = errors.New("media: unknown format")
+
+// Info describes a media file
+type Info struct {
+	// Format is the container format, one of mp3, wav, ogg, mp4 or webm
+	Format string
+
+	// Type is either Audio or Video
+	Type string
+
+	// Duration is the length of the media. It's 0 if the container didn't
+	// say
+	Duration time.Duration
+}
+
+// Probe reads the container headers from r, which should be size bytes long,
+// and returns information about the media. If the format isn't recognized
+// ErrUnknownFormat is returned
+func Probe(r io.ReaderAt, size int64) (*Info, error) {
+	hdr := make([]byte, 12)
+	n, err := r.ReadAt(hdr, 0)
+	if n < len(hdr) {
+		if err == nil || err == io.EOF {
+			err = ErrUnknownFormat
+		}
+		return nil, err
+	}
+
+	switch {
+	case bytes.Equal(hdr[0:4], []byte("RIFF")) && bytes.Equal(hdr[8:12], []byte("WAVE")):
+		return probeWAV(r, size)
+	case bytes.Equal(hdr[0:4], []byte("OggS")):
+		return probeOgg(r, size)
+	case bytes.Equal(hdr[0:4], []byte{0x1a, 0x45, 0xdf, 0xa3}):
+		return probeWebM(r, size)
+	case isMP4Box(string(hdr[4:8])):
+		return probeMP4(r, size)
+	case bytes.Equal(hdr[0:3], []byte("ID3")) || isMP3Sync(hdr):
+		return probeMP3(r, size)
+	}
+	return nil, ErrUnknownFormat
+}
+
+// readAt is ReadAt but it only returns an error if the whole buffer couldn't
+// be filled
+func readAt(r io.ReaderAt, b []byte, off int64) error {
+	n, err := r.ReadAt(b, off)
+	if n == len(b) {
+		return nil
+	}
+	if err == nil || err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// seconds returns the duration of n units at the given rate per second
+func seconds(n, rate float64) time.Duration {
+	if rate <= 0 {
+		return 0
+	}
+	return time.Duration(n / rate * float64(time.Second))
+}
@@ -0,0 +1,247 @@
+package media
+
+import (
+	. "testing"
+
+	"bytes"
+	"encoding/binary"
+	"math"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func probe(t *T, b []byte) *Info {
+	info, err := Probe(bytes.NewReader(b), int64(len(b)))
+	require.Nil(t, err)
+	return info
+}
+
+func le32(i uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, i)
+	return b
+}
+
+func be32(i uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, i)
+	return b
+}
+
+func join(bs ...[]byte) []byte {
+	return bytes.Join(bs, nil)
+}
+
+func TestWAV(t *T) {
+	// 8kHz, mono, 16 bit so 16000 bytes per second
+	fmtChunk := join(
+		[]byte{1, 0, 1, 0}, le32(8000), le32(16000), []byte{2, 0, 16, 0},
+	)
+	data := make([]byte, 32000)
+	b := join(
+		[]byte("RIFF"), le32(uint32(4+8+len(fmtChunk)+8+len(data))), []byte("WAVE"),
+		[]byte("fmt "), le32(uint32(len(fmtChunk))), fmtChunk,
+		[]byte("data"), le32(uint32(len(data))), data,
+	)
+	info := probe(t, b)
+	assert.Equal(t, "wav", info.Format)
+	assert.Equal(t, Audio, info.Type)
+	assert.Equal(t, 2*time.Second, info.Duration)
+}
+
+func TestMP3(t *T) {
+	// MPEG 1 layer III, 128kbps, 44.1kHz: 417 byte frames of 1152 samples
+	frame := make([]byte, 417)
+	copy(frame, []byte{0xff, 0xfb, 0x90, 0x00})
+	var b []byte
+	for i := 0; i < 100; i++ {
+		b = append(b, frame...)
+	}
+	info := probe(t, b)
+	assert.Equal(t, "mp3", info.Format)
+	assert.Equal(t, Audio, info.Type)
+	assert.Equal(t, seconds(115200, 44100), info.Duration)
+
+	// with an ID3v2 tag at the start and an ID3v1 tag at the end
+	id3 := join([]byte("ID3"), []byte{3, 0, 0, 0, 0, 0, 20}, make([]byte, 20))
+	tag := append([]byte("TAG"), make([]byte, 125)...)
+	info = probe(t, join(id3, b, tag))
+	assert.Equal(t, seconds(115200, 44100), info.Duration)
+
+	_, err := Probe(bytes.NewReader(frame[:100]), 100)
+	assert.NotNil(t, err)
+}
+
+func oggPage(typ byte, granule int64, serial uint32, body []byte) []byte {
+	h := join(
+		[]byte("OggS"), []byte{0, typ},
+		make([]byte, 8), le32(serial), le32(0), le32(0),
+	)
+	binary.LittleEndian.PutUint64(h[6:], uint64(granule))
+	var segs []byte
+	n := len(body)
+	for ; n >= 255; n -= 255 {
+		segs = append(segs, 255)
+	}
+	segs = append(segs, byte(n))
+	return join(h, []byte{byte(len(segs))}, segs, body)
+}
+
+func TestOgg(t *T) {
+	vorbis := join([]byte("\x01vorbis"), le32(0), []byte{2}, le32(44100), make([]byte, 13))
+	b := join(
+		oggPage(2, 0, 1, vorbis),
+		oggPage(0, -1, 1, make([]byte, 300)),
+		oggPage(0, 44100, 1, make([]byte, 300)),
+		oggPage(4, 88200, 1, make([]byte, 300)),
+	)
+	info := probe(t, b)
+	assert.Equal(t, "ogg", info.Format)
+	assert.Equal(t, Audio, info.Type)
+	assert.Equal(t, 2*time.Second, info.Duration)
+
+	opus := join([]byte("OpusHead"), []byte{1, 1}, []byte{0x38, 0x01}, le32(48000), make([]byte, 3))
+	b = join(
+		oggPage(2, 0, 7, opus),
+		oggPage(4, 48000*3+312, 7, make([]byte, 10)),
+	)
+	info = probe(t, b)
+	assert.Equal(t, Audio, info.Type)
+	assert.Equal(t, 3*time.Second, info.Duration)
+
+	// theora at 25fps with a keyframe shift of 6
+	theora := make([]byte, 42)
+	copy(theora, "\x80theora")
+	copy(theora[22:], be32(25))
+	copy(theora[26:], be32(1))
+	binary.BigEndian.PutUint16(theora[40:], 6<<5)
+	b = join(
+		oggPage(2, 0, 1, theora),
+		oggPage(2, 0, 2, vorbis),
+		// keyframe 200 plus 50 frames
+		oggPage(0, 200<<6|50, 1, make([]byte, 10)),
+		oggPage(4, 44100, 2, make([]byte, 10)),
+	)
+	info = probe(t, b)
+	assert.Equal(t, Video, info.Type)
+	assert.Equal(t, 10*time.Second, info.Duration)
+}
+
+func box(typ string, body ...[]byte) []byte {
+	b := join(body...)
+	return join(be32(uint32(8+len(b))), []byte(typ), b)
+}
+
+func TestMP4(t *T) {
+	mvhd := join(make([]byte, 12), be32(600), be32(600*90), make([]byte, 80))
+	hdlr := func(h string) []byte {
+		return box("trak", box("mdia", box("hdlr", make([]byte, 8), []byte(h), make([]byte, 13))))
+	}
+	b := join(
+		box("ftyp", []byte("isom"), be32(0)),
+		box("mdat", make([]byte, 100)),
+		box("moov", box("mvhd", mvhd), hdlr("soun"), hdlr("vide")),
+	)
+	info := probe(t, b)
+	assert.Equal(t, "mp4", info.Format)
+	assert.Equal(t, Video, info.Type)
+	assert.Equal(t, 90*time.Second, info.Duration)
+
+	b = join(
+		box("ftyp", []byte("M4A "), be32(0)),
+		box("moov", box("mvhd", mvhd), hdlr("soun")),
+	)
+	info = probe(t, b)
+	assert.Equal(t, Audio, info.Type)
+
+	_, err := Probe(bytes.NewReader(b[:30]), 30)
+	assert.NotNil(t, err)
+}
+
+// el makes an ebml element, ids are passed with their marker bits
+func el(id uint32, body ...[]byte) []byte {
+	b := join(body...)
+	var idb []byte
+	for i := 3; i >= 0; i-- {
+		if c := byte(id >> uint(8*i)); c != 0 || len(idb) > 0 {
+			idb = append(idb, c)
+		}
+	}
+	size := make([]byte, 8)
+	binary.BigEndian.PutUint64(size, uint64(len(b))|1<<56)
+	return join(idb, size, b)
+}
+
+func TestWebM(t *T) {
+	dur := make([]byte, 8)
+	binary.BigEndian.PutUint64(dur, math.Float64bits(61500))
+	b := join(
+		el(ebmlHeaderID, el(ebmlDocTypeID, []byte("webm"))),
+		el(mkvSegmentID,
+			el(mkvInfoID, el(mkvTimescaleID, []byte{0x0f, 0x42, 0x40}), el(mkvDurationID, dur)),
+			el(mkvTracksID, el(mkvTrackEntryID, el(mkvTrackTypeID, []byte{2}))),
+			el(mkvClusterID, make([]byte, 50)),
+		),
+	)
+	info := probe(t, b)
+	assert.Equal(t, "webm", info.Format)
+	assert.Equal(t, Audio, info.Type)
+	assert.Equal(t, 61500*time.Millisecond, info.Duration)
+}
+
+func TestWebMTruncated(t *T) {
+	// the DocType's header runs past the end of the EBML header
+	docType := el(ebmlDocTypeID, []byte("webm"))
+	b := join(el(ebmlHeaderID, docType[:4]), docType[4:], make([]byte, 16))
+	_, err := Probe(bytes.NewReader(b), int64(len(b)))
+	assert.NotNil(t, err)
+
+	// and an Info child's
+	for _, id := range []uint32{mkvDurationID, mkvTimescaleID} {
+		inner := el(id, make([]byte, 8))
+		b = join(
+			el(ebmlHeaderID, el(ebmlDocTypeID, []byte("webm"))),
+			el(mkvSegmentID, el(mkvInfoID, inner[:3])),
+			inner[3:],
+		)
+		_, err = Probe(bytes.NewReader(b), int64(len(b)))
+		assert.NotNil(t, err)
+	}
+}
+
+func TestUnknown(t *T) {
+	_, err := Probe(bytes.NewReader([]byte("hello world, not media")), 22)
+	assert.Equal(t, ErrUnknownFormat, err)
+}
+
+func TestDetectContentType(t *T) {
+	frame := make([]byte, 417)
+	copy(frame, []byte{0xff, 0xfb, 0x90, 0x00})
+	mp3 := join(frame, frame)
+	vorbis := join([]byte("\x01vorbis"), le32(0), []byte{2}, le32(44100), make([]byte, 13))
+
+	for ct, b := range map[string][]byte{
+		"audio/mpeg":      mp3,
+		"audio/ogg":       oggPage(2, 0, 1, vorbis),
+		"audio/mp4":       box("ftyp", []byte("M4A "), be32(0)),
+		"video/quicktime": box("ftyp", []byte("qt  "), be32(0)),
+		"image/svg+xml":   []byte(`<?xml version="1.0"?><!-- hi --><svg xmlns="http://www.w3.org/2000/svg"/>`),
+		"image/png":       []byte("\x89PNG\x0d\x0a\x1a\x0a"),
+		"application/pdf": []byte("%PDF-1.4"),
+	} {
+		assert.Equal(t, ct, DetectContentType(b), ct)
+	}
+}
+
+func TestMatchType(t *T) {
+	allowed := []string{"image/*", "audio/wav", "application/pdf"}
+	assert.True(t, MatchType("image/png", allowed))
+	assert.True(t, MatchType("audio/wave", allowed))
+	assert.True(t, MatchType("Application/PDF", allowed))
+	assert.False(t, MatchType("text/plain; charset=utf-8", allowed))
+	assert.False(t, MatchType("imagex/png", allowed))
+	assert.False(t, MatchType("image/svg+xml", allowed))
+	assert.True(t, MatchType("image/svg+xml", append(allowed, "image/svg+xml")))
+}
@@ -0,0 +1,127 @@
+package media
+
+import (
+	"errors"
+	"io"
+)
+
+// bitrates in kbps indexed by [mpeg1 ? 0 : 1][layer-1][index]
+var mp3Bitrates = [2][3][16]int{
+	{
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, 0},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 0},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+	},
+	{
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, 0},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+	},
+}
+
+// sample rates indexed by [version][index] where version is the 2 bits from
+// the header: 0 is MPEG 2.5, 2 is MPEG 2 and 3 is MPEG 1
+var mp3SampleRates = [4][3]int{
+	{11025, 12000, 8000},
+	{},
+	{22050, 24000, 16000},
+	{44100, 48000, 32000},
+}
+
+type mp3Frame struct {
+	sampleRate int
+	samples    int
+	length     int64
+}
+
+func isMP3Sync(b []byte) bool {
+	_, ok := parseMP3Frame(b)
+	return ok
+}
+
+// parseMP3Frame parses the 4 byte frame header at the start of b
+func parseMP3Frame(b []byte) (mp3Frame, bool) {
+	var f mp3Frame
+	if len(b) < 4 || b[0] != 0xff || b[1]&0xe0 != 0xe0 {
+		return f, false
+	}
+	version := int(b[1]>>3) & 3
+	layer := 4 - int(b[1]>>1)&3
+	bitrateIdx := int(b[2] >> 4)
+	rateIdx := int(b[2]>>2) & 3
+	padding := int64(b[2]>>1) & 1
+	if version == 1 || layer == 4 || rateIdx == 3 {
+		return f, false
+	}
+	v := 1
+	if version == 3 {
+		v = 0
+	}
+	bitrate := mp3Bitrates[v][layer-1][bitrateIdx] * 1000
+	if bitrate == 0 {
+		return f, false
+	}
+	f.sampleRate = mp3SampleRates[version][rateIdx]
+
+	switch {
+	case layer == 1:
+		f.samples = 384
+		f.length = (12*int64(bitrate)/int64(f.sampleRate) + padding) * 4
+	case layer == 3 && version != 3:
+		f.samples = 576
+		f.length = 72*int64(bitrate)/int64(f.sampleRate) + padding
+	default:
+		f.samples = 1152
+		f.length = 144*int64(bitrate)/int64(f.sampleRate) + padding
+	}
+	return f, true
+}
+
+// probeMP3 skips any ID3v2 tag and then walks every frame adding up the
+// number of samples. This handles both constant and variable bitrates
+func probeMP3(r io.ReaderAt, size int64) (*Info, error) {
+	var off int64
+	hdr := make([]byte, 10)
+	if err := readAt(r, hdr, 0); err != nil {
+		return nil, err
+	}
+	if string(hdr[0:3]) == "ID3" {
+		// the size is a 28 bit "syncsafe" integer
+		n := int64(hdr[6])<<21 | int64(hdr[7])<<14 | int64(hdr[8])<<7 | int64(hdr[9])
+		off = 10 + n
+		// a footer is present
+		if hdr[5]&0x10 != 0 {
+			off += 10
+		}
+	}
+
+	var frames, samples int64
+	var sampleRate int
+	b := make([]byte, 4)
+	for off+4 <= size {
+		if err := readAt(r, b, off); err != nil {
+			return nil, err
+		}
+		f, ok := parseMP3Frame(b)
+		// anything after the frames, like an ID3v1 tag, is ignored
+		if !ok || off+f.length > size {
+			break
+		}
+		if sampleRate == 0 {
+			sampleRate = f.sampleRate
+		}
+		frames++
+		samples += int64(f.samples)
+		off += f.length
+	}
+
+	// a single frame is too easy to find by accident unless its the whole file
+	if frames == 0 || (frames == 1 && off != size) {
+		return nil, errors.New("media: no mp3 frames found")
+	}
+	return &Info{
+		Format:   "mp3",
+		Type:     Audio,
+		Duration: seconds(float64(samples), float64(sampleRate)),
+	}, nil
+}
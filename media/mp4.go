@@ -0,0 +1,140 @@
+package media
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+// isMP4Box returns true if typ is a box that's expected at the start of an
+// mp4 or quicktime file
+func isMP4Box(typ string) bool {
+	switch typ {
+	case "ftyp", "moov", "mdat", "free", "skip", "wide", "pnot":
+		return true
+	}
+	return false
+}
+
+// eachBox calls fn for every box between start and end with the box's type,
+// the offset of its contents and the offset of its end
+func eachBox(r io.ReaderAt, start, end int64, fn func(typ string, body, end int64) error) error {
+	hdr := make([]byte, 16)
+	for off := start; off+8 <= end; {
+		if err := readAt(r, hdr[:8], off); err != nil {
+			return err
+		}
+		n := int64(binary.BigEndian.Uint32(hdr))
+		typ := string(hdr[4:8])
+		body := off + 8
+		switch n {
+		case 0:
+			// the box goes until the end
+			n = end - off
+		case 1:
+			if err := readAt(r, hdr[8:], off+8); err != nil {
+				return err
+			}
+			n = int64(binary.BigEndian.Uint64(hdr[8:]))
+			body += 8
+		}
+		if n < body-off || off+n > end {
+			return errors.New("media: invalid mp4 box size")
+		}
+		if err := fn(typ, body, off+n); err != nil {
+			return err
+		}
+		off += n
+	}
+	return nil
+}
+
+// probeMP4 finds the moov box and reads the duration from its mvhd box and
+// the type from the handler of each of the tracks
+func probeMP4(r io.ReaderAt, size int64) (*Info, error) {
+	info := &Info{Format: "mp4"}
+	var foundMoov, hasAudio bool
+	err := eachBox(r, 0, size, func(typ string, start, end int64) error {
+		if typ != "moov" {
+			return nil
+		}
+		foundMoov = true
+		return eachBox(r, start, end, func(typ string, start, end int64) error {
+			switch typ {
+			case "mvhd":
+				d, err := mvhdDuration(r, start)
+				info.Duration = d
+				return err
+			case "trak":
+				h, err := trakHandler(r, start, end)
+				if h == "vide" {
+					info.Type = Video
+				} else if h == "soun" {
+					hasAudio = true
+				}
+				return err
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !foundMoov {
+		return nil, errors.New("media: mp4 has no moov box")
+	}
+	if info.Type == "" {
+		if !hasAudio {
+			return nil, errors.New("media: mp4 has no audio or video tracks")
+		}
+		info.Type = Audio
+	}
+	return info, nil
+}
+
+func mvhdDuration(r io.ReaderAt, start int64) (time.Duration, error) {
+	b := make([]byte, 32)
+	if err := readAt(r, b[:4], start); err != nil {
+		return 0, err
+	}
+	var timescale, duration uint64
+	if b[0] == 1 {
+		// version 1 has 64 bit creation, modification and duration
+		if err := readAt(r, b[:32], start); err != nil {
+			return 0, err
+		}
+		timescale = uint64(binary.BigEndian.Uint32(b[20:]))
+		duration = binary.BigEndian.Uint64(b[24:])
+	} else {
+		if err := readAt(r, b[:20], start); err != nil {
+			return 0, err
+		}
+		timescale = uint64(binary.BigEndian.Uint32(b[12:]))
+		duration = uint64(binary.BigEndian.Uint32(b[16:]))
+	}
+	return seconds(float64(duration), float64(timescale)), nil
+}
+
+// trakHandler returns the handler type, like "vide" or "soun", of the track
+func trakHandler(r io.ReaderAt, start, end int64) (string, error) {
+	var h string
+	err := eachBox(r, start, end, func(typ string, start, end int64) error {
+		if typ != "mdia" {
+			return nil
+		}
+		return eachBox(r, start, end, func(typ string, start, end int64) error {
+			if typ != "hdlr" {
+				return nil
+			}
+			// version and flags then pre_defined then handler_type
+			b := make([]byte, 4)
+			if err := readAt(r, b, start+8); err != nil {
+				return err
+			}
+			h = string(b)
+			return nil
+		})
+	})
+	return h, err
+}
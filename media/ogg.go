@@ -0,0 +1,126 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+// oggStream is a single logical stream in an ogg file
+type oggStream struct {
+	typ string
+
+	// rate is the number of granules per second for audio streams
+	rate    float64
+	preSkip int64
+
+	// theora granules are split into the last keyframe and the frames since
+	// then using the shift
+	frameRate float64
+	kfShift   uint
+
+	granule int64
+}
+
+func (s *oggStream) duration() time.Duration {
+	if s.typ == Video {
+		frames := s.granule>>s.kfShift + s.granule&(1<<s.kfShift-1)
+		return seconds(float64(frames), s.frameRate)
+	}
+	return seconds(float64(s.granule-s.preSkip), s.rate)
+}
+
+// newOggStream identifies the codec from the first packet of a stream. nil is
+// returned for codecs that aren't supported
+func newOggStream(p []byte) *oggStream {
+	switch {
+	case len(p) >= 16 && bytes.HasPrefix(p, []byte("\x01vorbis")):
+		return &oggStream{
+			typ:  Audio,
+			rate: float64(binary.LittleEndian.Uint32(p[12:])),
+		}
+	case len(p) >= 12 && bytes.HasPrefix(p, []byte("OpusHead")):
+		// opus granules are always at 48kHz
+		return &oggStream{
+			typ:     Audio,
+			rate:    48000,
+			preSkip: int64(binary.LittleEndian.Uint16(p[10:])),
+		}
+	case len(p) >= 42 && bytes.HasPrefix(p, []byte("\x80theora")):
+		num := float64(binary.BigEndian.Uint32(p[22:]))
+		den := float64(binary.BigEndian.Uint32(p[26:]))
+		if den == 0 {
+			return nil
+		}
+		return &oggStream{
+			typ:       Video,
+			frameRate: num / den,
+			kfShift:   uint(binary.BigEndian.Uint16(p[40:])>>5) & 0x1f,
+		}
+	}
+	return nil
+}
+
+// probeOgg walks every page in the file. The first page of each stream is used
+// to identify its codec and the last granule position of each stream is used
+// to get its duration
+func probeOgg(r io.ReaderAt, size int64) (*Info, error) {
+	streams := map[uint32]*oggStream{}
+	hdr := make([]byte, 27)
+	var off int64
+	for off+27 <= size {
+		if err := readAt(r, hdr, off); err != nil {
+			return nil, err
+		}
+		if string(hdr[0:4]) != "OggS" {
+			break
+		}
+		segs := make([]byte, hdr[26])
+		if err := readAt(r, segs, off+27); err != nil {
+			return nil, err
+		}
+		var bodyLen int64
+		for _, s := range segs {
+			bodyLen += int64(s)
+		}
+		body := off + 27 + int64(len(segs))
+		serial := binary.LittleEndian.Uint32(hdr[14:])
+		granule := int64(binary.LittleEndian.Uint64(hdr[6:]))
+
+		// beginning of stream
+		if hdr[5]&0x02 != 0 {
+			p := make([]byte, 64)
+			if bodyLen < int64(len(p)) {
+				p = p[:bodyLen]
+			}
+			if err := readAt(r, p, body); err != nil {
+				return nil, err
+			}
+			if s := newOggStream(p); s != nil {
+				streams[serial] = s
+			}
+		} else if s, ok := streams[serial]; ok && granule != -1 {
+			s.granule = granule
+		}
+		off = body + bodyLen
+	}
+
+	if len(streams) == 0 {
+		return nil, errors.New("media: no supported ogg streams found")
+	}
+	info := &Info{
+		Format: "ogg",
+		Type:   Audio,
+	}
+	for _, s := range streams {
+		if s.typ == Video {
+			info.Type = Video
+		}
+		if d := s.duration(); d > info.Duration {
+			info.Duration = d
+		}
+	}
+	return info, nil
+}
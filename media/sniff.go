@@ -0,0 +1,167 @@
+package media
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// SniffLen is the most bytes DetectContentType will look at
+const SniffLen = 512
+
+// magic is a content type identified by bytes at a given offset
+type magic struct {
+	off int
+	sig []byte
+	ct  string
+}
+
+// magicTable holds types that http.DetectContentType doesn't know about or
+// that it can't tell apart. It's checked before falling back to
+// http.DetectContentType
+var magicTable = []magic{
+	{0, []byte("II*\x00"), "image/tiff"},
+	{0, []byte("MM\x00*"), "image/tiff"},
+	{0, []byte("fLaC"), "audio/flac"},
+	{0, []byte("7z\xbc\xaf\x27\x1c"), "application/x-7z-compressed"},
+	{0, []byte("\xfd7zXZ\x00"), "application/x-xz"},
+	{0, []byte("BZh"), "application/x-bzip2"},
+	{4, []byte("ftypqt  "), "video/quicktime"},
+	{4, []byte("ftypM4A "), "audio/mp4"},
+	{4, []byte("ftypM4B "), "audio/mp4"},
+	{4, []byte("ftypavif"), "image/avif"},
+	{4, []byte("ftypheic"), "image/heic"},
+	{4, []byte("ftypheix"), "image/heic"},
+	{4, []byte("ftypmif1"), "image/heif"},
+	{4, []byte("ftyp3gp"), "video/3gpp"},
+	{4, []byte("moov"), "video/quicktime"},
+	{4, []byte("wide"), "video/quicktime"},
+}
+
+// aliases maps content types to the one DetectContentType returns
+var aliases = map[string]string{
+	"image/jpg":               "image/jpeg",
+	"image/pjpeg":             "image/jpeg",
+	"audio/wav":               "audio/wave",
+	"audio/x-wav":             "audio/wave",
+	"audio/vnd.wave":          "audio/wave",
+	"audio/mp3":               "audio/mpeg",
+	"audio/x-flac":            "audio/flac",
+	"audio/x-m4a":             "audio/mp4",
+	"application/gzip":        "application/x-gzip",
+	"application/x-pdf":       "application/pdf",
+	"application/x-rar":       "application/x-rar-compressed",
+	"application/vnd.rar":     "application/x-rar-compressed",
+	"application/x-zip":       "application/zip",
+	"video/matroska":          "video/x-matroska",
+	"application/x-matroska":  "video/x-matroska",
+	"application/x-quicktime": "video/quicktime",
+}
+
+// NormalizeType lowercases the content type, strips any parameters and
+// resolves common aliases so types can be compared
+func NormalizeType(ct string) string {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		mt = strings.ToLower(strings.TrimSpace(ct))
+	}
+	if a, ok := aliases[mt]; ok {
+		return a
+	}
+	return mt
+}
+
+// DetectContentType is like http.DetectContentType but it knows about more
+// types, can tell audio and video apart in ogg and matroska files, and finds
+// mp3s without ID3 tags
+func DetectContentType(b []byte) string {
+	if len(b) > SniffLen {
+		b = b[:SniffLen]
+	}
+	for _, m := range magicTable {
+		if len(b) >= m.off+len(m.sig) && bytes.Equal(b[m.off:m.off+len(m.sig)], m.sig) {
+			return m.ct
+		}
+	}
+
+	switch {
+	case bytes.HasPrefix(b, []byte("OggS")):
+		return sniffOgg(b)
+	case bytes.HasPrefix(b, []byte{0x1a, 0x45, 0xdf, 0xa3}):
+		if bytes.Contains(b, []byte("matroska")) {
+			return "video/x-matroska"
+		}
+	case isMP3Sync(b):
+		// make sure the next frame is there too, if we can see it
+		f, _ := parseMP3Frame(b)
+		if f.length+4 > int64(len(b)) || isMP3Sync(b[f.length:]) {
+			return "audio/mpeg"
+		}
+	case isSVG(b):
+		return "image/svg+xml"
+	}
+	return http.DetectContentType(b)
+}
+
+// sniffOgg looks at the first packet of the first page to identify the codec
+func sniffOgg(b []byte) string {
+	if len(b) > 27 {
+		p := b[27:]
+		if n := int(b[26]); len(p) > n {
+			p = p[n:]
+			switch {
+			case bytes.HasPrefix(p, []byte("\x01vorbis")),
+				bytes.HasPrefix(p, []byte("OpusHead")),
+				bytes.HasPrefix(p, []byte("Speex   ")),
+				bytes.HasPrefix(p, []byte("\x7fFLAC")):
+				return "audio/ogg"
+			case bytes.HasPrefix(p, []byte("\x80theora")):
+				return "video/ogg"
+			}
+		}
+	}
+	return "application/ogg"
+}
+
+// isSVG returns true if b starts with an svg tag, optionally after an xml
+// declaration, doctype and comments
+func isSVG(b []byte) bool {
+	b = bytes.TrimLeft(b, "\t\n\r \xef\xbb\xbf")
+	for len(b) > 0 && bytes.HasPrefix(b, []byte("<")) {
+		switch {
+		case bytes.HasPrefix(b, []byte("<svg")):
+			return true
+		case bytes.HasPrefix(b, []byte("<?")),
+			bytes.HasPrefix(b, []byte("<!")):
+			i := bytes.IndexByte(b, '>')
+			if i < 0 {
+				return false
+			}
+			b = bytes.TrimLeft(b[i+1:], "\t\n\r ")
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// MatchType returns true if the content type matches any of the allowed
+// types. An allowed type can be a wildcard like "image/*", but SVGs can run
+// scripts so they have to be allowed explicitly
+func MatchType(ct string, allowed []string) bool {
+	ct = NormalizeType(ct)
+	for _, a := range allowed {
+		a = NormalizeType(a)
+		if a == ct {
+			return true
+		}
+		if ct == "image/svg+xml" {
+			continue
+		}
+		if strings.HasSuffix(a, "/*") && strings.HasPrefix(ct, a[:len(a)-1]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package media
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// probeWAV walks the RIFF chunks looking for the byte rate in the "fmt " chunk
+// and the length of the "data" chunk
+func probeWAV(r io.ReaderAt, size int64) (*Info, error) {
+	var byteRate uint32
+	hdr := make([]byte, 8)
+	for off := int64(12); off+8 <= size; {
+		if err := readAt(r, hdr, off); err != nil {
+			return nil, err
+		}
+		n := int64(binary.LittleEndian.Uint32(hdr[4:]))
+		switch string(hdr[0:4]) {
+		case "fmt ":
+			f := make([]byte, 12)
+			if err := readAt(r, f, off+8); err != nil {
+				return nil, err
+			}
+			byteRate = binary.LittleEndian.Uint32(f[8:])
+		case "data":
+			if byteRate == 0 {
+				return nil, errors.New("media: wav data chunk before fmt chunk")
+			}
+			// streaming writers might not know the length so trust the size
+			if off+8+n > size {
+				n = size - off - 8
+			}
+			return &Info{
+				Format:   "wav",
+				Type:     Audio,
+				Duration: seconds(float64(n), float64(byteRate)),
+			}, nil
+		}
+		// chunks are padded to an even length
+		off += 8 + n + n&1
+	}
+	return nil, errors.New("media: wav has no data chunk")
+}
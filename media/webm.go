@@ -0,0 +1,207 @@
+package media
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// matroska element ids that are used
+const (
+	ebmlHeaderID    = 0x1a45dfa3
+	ebmlDocTypeID   = 0x4282
+	mkvSegmentID    = 0x18538067
+	mkvInfoID       = 0x1549a966
+	mkvTimescaleID  = 0x2ad7b1
+	mkvDurationID   = 0x4489
+	mkvTracksID     = 0x1654ae6b
+	mkvTrackEntryID = 0xae
+	mkvTrackTypeID  = 0x83
+	mkvClusterID    = 0x1f43b675
+)
+
+var errInvalidWebM = errors.New("media: invalid webm")
+
+// unknownSize is returned by readVint for sizes with all bits set
+const unknownSize = -1
+
+// readVint reads an EBML variable length integer at off and returns its value
+// and length. If keepMarker is true the length marker bit is kept, which is
+// how element ids are read
+func readVint(r io.ReaderAt, off int64, keepMarker bool) (int64, int64, error) {
+	b := make([]byte, 8)
+	if err := readAt(r, b[:1], off); err != nil {
+		return 0, 0, err
+	}
+	l := 1
+	for l <= 8 && b[0]&(0x80>>uint(l-1)) == 0 {
+		l++
+	}
+	if l > 8 {
+		return 0, 0, errors.New("media: invalid ebml integer")
+	}
+	if err := readAt(r, b[1:l], off+1); err != nil {
+		return 0, 0, err
+	}
+	if !keepMarker {
+		b[0] &= 0xff >> uint(l)
+	}
+	var v uint64
+	for i := 0; i < l; i++ {
+		v = v<<8 | uint64(b[i])
+	}
+	if !keepMarker && v == 1<<uint(7*l)-1 {
+		return unknownSize, int64(l), nil
+	}
+	return int64(v), int64(l), nil
+}
+
+// eachElement calls fn for every element between start and end with the
+// element's id, the offset of its contents and the offset of its end
+func eachElement(r io.ReaderAt, start, end int64, fn func(id, body, end int64) (bool, error)) error {
+	for off := start; off < end; {
+		id, idLen, err := readVint(r, off, true)
+		if err != nil {
+			return err
+		}
+		n, sizeLen, err := readVint(r, off+idLen, false)
+		if err != nil {
+			return err
+		}
+		body := off + idLen + sizeLen
+		if body > end || (n < 0 && n != unknownSize) {
+			return errInvalidWebM
+		}
+		elEnd := body + n
+		if n == unknownSize || elEnd > end {
+			elEnd = end
+		}
+		cont, err := fn(id, body, elEnd)
+		if err != nil || !cont {
+			return err
+		}
+		off = elEnd
+	}
+	return nil
+}
+
+// readUint reads a big endian unsigned integer of any length up to 8 bytes
+func readUint(r io.ReaderAt, start, end int64) (uint64, error) {
+	if end < start || end-start > 8 {
+		return 0, errors.New("media: invalid ebml uint")
+	}
+	b := make([]byte, end-start)
+	if err := readAt(r, b, start); err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+// readFloat reads a big endian 4 or 8 byte float
+func readFloat(r io.ReaderAt, start, end int64) (float64, error) {
+	if end-start != 4 && end-start != 8 {
+		return 0, errors.New("media: invalid ebml float")
+	}
+	b := make([]byte, end-start)
+	if err := readAt(r, b, start); err != nil {
+		return 0, err
+	}
+	if len(b) == 4 {
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+}
+
+// probeWebM reads the Info and Tracks elements at the start of the Segment.
+// It stops at the first Cluster since those are only media data
+func probeWebM(r io.ReaderAt, size int64) (*Info, error) {
+	var docType string
+	var hasAudio, hasVideo, foundInfo bool
+	timescale := 1000000.0
+	var duration float64
+
+	err := eachElement(r, 0, size, func(id, start, end int64) (bool, error) {
+		switch id {
+		case ebmlHeaderID:
+			return true, eachElement(r, start, end, func(id, start, end int64) (bool, error) {
+				if id == ebmlDocTypeID && end >= start && end-start < 32 {
+					b := make([]byte, end-start)
+					if err := readAt(r, b, start); err != nil {
+						return false, err
+					}
+					docType = string(b)
+				}
+				return true, nil
+			})
+		case mkvSegmentID:
+			return false, eachElement(r, start, end, func(id, start, end int64) (bool, error) {
+				switch id {
+				case mkvInfoID:
+					foundInfo = true
+					return true, eachElement(r, start, end, func(id, start, end int64) (bool, error) {
+						var err error
+						switch id {
+						case mkvTimescaleID:
+							var ts uint64
+							ts, err = readUint(r, start, end)
+							timescale = float64(ts)
+						case mkvDurationID:
+							duration, err = readFloat(r, start, end)
+						}
+						return err == nil, err
+					})
+				case mkvTracksID:
+					return true, eachElement(r, start, end, func(id, start, end int64) (bool, error) {
+						if id != mkvTrackEntryID {
+							return true, nil
+						}
+						return true, eachElement(r, start, end, func(id, start, end int64) (bool, error) {
+							if id != mkvTrackTypeID {
+								return true, nil
+							}
+							t, err := readUint(r, start, end)
+							if t == 1 {
+								hasVideo = true
+							} else if t == 2 {
+								hasAudio = true
+							}
+							return err == nil, err
+						})
+					})
+				case mkvClusterID:
+					return false, nil
+				}
+				return true, nil
+			})
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if docType != "webm" && docType != "matroska" {
+		return nil, ErrUnknownFormat
+	}
+	if !foundInfo {
+		return nil, errors.New("media: webm has no info element")
+	}
+	info := &Info{
+		Format:   "webm",
+		Duration: seconds(duration*timescale, 1e9),
+	}
+	switch {
+	case hasVideo:
+		info.Type = Video
+	case hasAudio:
+		info.Type = Audio
+	default:
+		return nil, errors.New("media: webm has no audio or video tracks")
+	}
+	return info, nil
+}
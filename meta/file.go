@@ -0,0 +1,113 @@
+package meta
+
+import (
+	"encoding/json"
+	"github.com/levenlabs/dank/filelog"
+	"strings"
+)
+
+// FileStore is a Store that keeps records in memory but also appends every
+// change to a file so records survive restarts. The file is compacted,
+// dropping replaced and deleted records, when it's opened and every so many
+// changes
+type FileStore struct {
+	mem *MemoryStore
+	log *filelog.Log
+}
+
+// NewFileStore opens, or creates, the file at path and loads any existing
+// records from it
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{mem: NewMemoryStore()}
+	lg, err := filelog.Open(path, s.load, s.snapshot)
+	if err != nil {
+		return nil, err
+	}
+	s.log = lg
+	return s, nil
+}
+
+// each line in the file is either "+ <json record>" or "- <key>"
+func (s *FileStore) load(line string) error {
+	switch {
+	case strings.HasPrefix(line, "+ "):
+		r := &Record{}
+		if err := json.Unmarshal([]byte(line[2:]), r); err != nil {
+			// a partially written last line is expected if we crashed
+			// mid-write so just skip it
+			return nil
+		}
+		s.mem.records[key(r.Filename)] = *r
+	case strings.HasPrefix(line, "- "):
+		delete(s.mem.records, line[2:])
+	}
+	return nil
+}
+
+// snapshot writes a line for every current record when the file is compacted
+func (s *FileStore) snapshot(write func(string) error) error {
+	return s.mem.each(func(r *Record) error {
+		line, err := putLine(r)
+		if err == nil {
+			err = write(line)
+		}
+		return err
+	})
+}
+
+func putLine(r *Record) (string, error) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	return "+ " + string(b) + "\n", nil
+}
+
+// restore returns a func that puts back the record for filename as it is now,
+// for undoing a change that couldn't be written
+func (s *FileStore) restore(filename string) func() {
+	old, err := s.mem.Get(filename)
+	return func() {
+		if err == nil {
+			s.mem.Put(old)
+		} else {
+			s.mem.Delete(filename)
+		}
+	}
+}
+
+// Put implements the Store interface
+func (s *FileStore) Put(r *Record) error {
+	line, err := putLine(r)
+	if err != nil {
+		return err
+	}
+	var undo func()
+	return s.log.Append(line, func() error {
+		undo = s.restore(r.Filename)
+		return s.mem.Put(r)
+	}, func() {
+		undo()
+	})
+}
+
+// Get implements the Store interface
+func (s *FileStore) Get(filename string) (*Record, error) {
+	return s.mem.Get(filename)
+}
+
+// Delete implements the Store interface
+func (s *FileStore) Delete(filename string) error {
+	var undo func()
+	return s.log.Append("- "+key(filename)+"\n", func() error {
+		undo = s.restore(filename)
+		return s.mem.Delete(filename)
+	}, func() {
+		undo()
+	})
+}
+
+// Close closes the underlying file. The FileStore cannot be used afterwards
+func (s *FileStore) Close() error {
+	return s.log.Close()
+}
@@ -0,0 +1,59 @@
+package meta
+
+import (
+	"sync"
+)
+
+// MemoryStore is a Store that keeps all records in memory. Records are lost
+// when the process exits
+type MemoryStore struct {
+	l       sync.RWMutex
+	records map[string]Record
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		records: map[string]Record{},
+	}
+}
+
+// Put implements the Store interface
+func (s *MemoryStore) Put(r *Record) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.records[key(r.Filename)] = *r
+	return nil
+}
+
+// Get implements the Store interface
+func (s *MemoryStore) Get(filename string) (*Record, error) {
+	s.l.RLock()
+	defer s.l.RUnlock()
+	r, ok := s.records[key(filename)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &r, nil
+}
+
+// Delete implements the Store interface
+func (s *MemoryStore) Delete(filename string) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	delete(s.records, key(filename))
+	return nil
+}
+
+// each calls fn for every record. It's used by FileStore when compacting
+func (s *MemoryStore) each(fn func(*Record) error) error {
+	s.l.RLock()
+	defer s.l.RUnlock()
+	for _, r := range s.records {
+		r := r
+		if err := fn(&r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+// Package meta provides stores used to remember information about uploaded
+// files that seaweed doesn't keep, like the name it was uploaded with and who
+// uploaded it
+package meta
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned from Get when there's no record for the filename
+var ErrNotFound = errors.New("meta: no record for filename")
+
+// Record holds the information about a single uploaded file
+type Record struct {
+	// Filename is the dank filename the file was uploaded to
+	Filename string `json:"filename"`
+
+	// Name is the name of the file on the uploader's machine, if it was sent
+	Name string `json:"name,omitempty"`
+
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+
+	// Owner is the owner the signature used to upload was bound to, if any
+	Owner string `json:"owner,omitempty"`
+
+	Uploaded time.Time `json:"uploaded"`
+
+	// Expires is when the file expires, if it was uploaded with a ttl. It's
+	// recorded here since not every backend can say when a file expires
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// Store records information about uploaded files. Implementations must be
+// safe for concurrent use
+type Store interface {
+	// Put stores the record, replacing any existing record for the same
+	// filename
+	Put(r *Record) error
+
+	// Get returns the record for the filename or ErrNotFound
+	Get(filename string) (*Record, error)
+
+	// Delete removes the record for the filename, if there is one
+	Delete(filename string) error
+}
+
+// key returns the key a filename's record is stored under. The extension is
+// ignored since dank ignores it everywhere else
+func key(filename string) string {
+	return strings.SplitN(filename, ".", 2)[0]
+}
@@ -0,0 +1,81 @@
+package meta
+
+import (
+	. "testing"
+
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testStore(t *T, s Store) {
+	_, err := s.Get("a")
+	assert.Equal(t, ErrNotFound, err)
+
+	r := &Record{
+		Filename:    "a.jpg",
+		Name:        "cat.jpg",
+		ContentType: "image/jpeg",
+		Size:        10,
+		Owner:       "1",
+		Uploaded:    time.Unix(1476719000, 0).UTC(),
+	}
+	require.Nil(t, s.Put(r))
+	r2, err := s.Get("a")
+	require.Nil(t, err)
+	assert.Equal(t, r, r2)
+	// the extension is ignored
+	r2, err = s.Get("a.png")
+	require.Nil(t, err)
+	assert.Equal(t, r, r2)
+
+	require.Nil(t, s.Put(&Record{Filename: "b"}))
+	require.Nil(t, s.Delete("b.jpg"))
+	_, err = s.Get("b")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestMemoryStore(t *T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestFileStore(t *T) {
+	dir, err := ioutil.TempDir("", "dank-meta")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "meta")
+
+	s, err := NewFileStore(path)
+	require.Nil(t, err)
+	testStore(t, s)
+	require.Nil(t, s.Put(&Record{Filename: "c", Size: 1}))
+	require.Nil(t, s.Put(&Record{Filename: "c", Size: 2}))
+	require.Nil(t, s.Close())
+
+	s, err = NewFileStore(path)
+	require.Nil(t, err)
+	r, err := s.Get("a")
+	require.Nil(t, err)
+	assert.Equal(t, "cat.jpg", r.Name)
+	r, err = s.Get("c")
+	require.Nil(t, err)
+	assert.Equal(t, int64(2), r.Size)
+	_, err = s.Get("b")
+	assert.Equal(t, ErrNotFound, err)
+
+	// records put after a compaction while running are kept too
+	s.log.CompactEvery = 1
+	require.Nil(t, s.Put(&Record{Filename: "d", Size: 3}))
+	require.Nil(t, s.Close())
+	s, err = NewFileStore(path)
+	require.Nil(t, err)
+	for _, f := range []string{"a", "c", "d"} {
+		_, err = s.Get(f)
+		assert.Nil(t, err, f)
+	}
+	require.Nil(t, s.Close())
+}
@@ -0,0 +1,135 @@
+// Package metrics keeps the prometheus metrics dank exposes on /metrics
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "dank",
+		Name:      "http_requests_total",
+		Help:      "Number of HTTP requests by handler and status code",
+	}, []string{"handler", "code"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "dank",
+		Name:      "http_request_duration_seconds",
+		Help:      "How long HTTP requests took by handler",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"handler"})
+
+	uploadedBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "dank",
+		Name:      "uploaded_bytes_total",
+		Help:      "Number of bytes of files successfully uploaded",
+	})
+
+	servedBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "dank",
+		Name:      "served_bytes_total",
+		Help:      "Number of bytes of files sent to clients",
+	})
+
+	rejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "dank",
+		Name:      "upload_rejections_total",
+		Help:      "Number of uploads rejected by reason",
+	}, []string{"reason"})
+
+	seaweedDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "dank",
+		Name:      "seaweed_request_duration_seconds",
+		Help:      "How long calls to seaweed took by operation",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"op"})
+
+	seaweedErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "dank",
+		Name:      "seaweed_errors_total",
+		Help:      "Number of calls to seaweed that failed by operation",
+	}, []string{"op"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		requests,
+		requestDuration,
+		uploadedBytes,
+		servedBytes,
+		rejections,
+		seaweedDuration,
+		seaweedErrors,
+	)
+}
+
+// httpError matches dank's http.HTTPError, which can't be imported here since
+// it uses this package
+type httpError interface {
+	Code() int
+	Reason() string
+}
+
+// clientError returns the error as an httpError if it's the client's fault
+func clientError(err error) (httpError, bool) {
+	he, ok := err.(httpError)
+	if !ok || he.Code() < 400 || he.Code() >= 500 {
+		return nil, false
+	}
+	return he, true
+}
+
+// Handler returns the http.Handler that serves the metrics
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObserveRequest records an HTTP request to the handler that finished with
+// the status code and started at start
+func ObserveRequest(handler string, code int, start time.Time) {
+	requests.WithLabelValues(handler, strconv.Itoa(code)).Inc()
+	requestDuration.WithLabelValues(handler).Observe(time.Since(start).Seconds())
+}
+
+// Uploaded records n bytes of a file being uploaded
+func Uploaded(n int64) {
+	uploadedBytes.Add(float64(n))
+}
+
+// Served records n bytes of a file being sent to a client
+func Served(n int64) {
+	servedBytes.Add(float64(n))
+}
+
+// Rejected records an upload being rejected because of err if it was the
+// uploader's fault. The reason comes from the error's Reason or is "other"
+func Rejected(err error) {
+	he, ok := clientError(err)
+	if !ok {
+		return
+	}
+	reason := he.Reason()
+	if reason == "" {
+		reason = "other"
+	}
+	rejections.WithLabelValues(reason).Inc()
+}
+
+// ObserveSeaweed records a call to seaweed for the operation that started at
+// start and returned *err. It takes a pointer so it can be deferred at the
+// start of a function with a named error. Errors that are the caller's fault,
+// like a file not being found, aren't counted as errors
+func ObserveSeaweed(op string, start time.Time, err *error) {
+	seaweedDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	if *err == nil {
+		return
+	}
+	if _, ok := clientError(*err); ok {
+		return
+	}
+	seaweedErrors.WithLabelValues(op).Inc()
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	. "testing"
+
+	"errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"time"
+)
+
+type testError struct {
+	code   int
+	reason string
+}
+
+func (e testError) Error() string  { return "test error" }
+func (e testError) Code() int      { return e.code }
+func (e testError) Reason() string { return e.reason }
+
+func TestRejected(t *T) {
+	size := testutil.ToFloat64(rejections.WithLabelValues("max_size"))
+	other := testutil.ToFloat64(rejections.WithLabelValues("other"))
+
+	Rejected(testError{413, "max_size"})
+	Rejected(testError{400, ""})
+	// not the uploader's fault
+	Rejected(testError{500, "max_size"})
+	Rejected(errors.New("failed"))
+
+	assert.Equal(t, size+1, testutil.ToFloat64(rejections.WithLabelValues("max_size")))
+	assert.Equal(t, other+1, testutil.ToFloat64(rejections.WithLabelValues("other")))
+}
+
+func TestObserveSeaweed(t *T) {
+	errs := testutil.ToFloat64(seaweedErrors.WithLabelValues("test"))
+
+	var err error
+	ObserveSeaweed("test", time.Now(), &err)
+	err = testError{404, ""}
+	ObserveSeaweed("test", time.Now(), &err)
+	err = errors.New("failed")
+	ObserveSeaweed("test", time.Now(), &err)
+
+	assert.Equal(t, errs+1, testutil.ToFloat64(seaweedErrors.WithLabelValues("test")))
+}
@@ -0,0 +1,96 @@
+package replay
+
+import (
+	"fmt"
+	"github.com/levenlabs/dank/filelog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileStore is a Store that keeps claims in memory but also appends every
+// change to a file so claims survive restarts. The file is compacted, dropping
+// expired and released keys, when it's opened and every so many changes
+type FileStore struct {
+	mem *MemoryStore
+	log *filelog.Log
+}
+
+// NewFileStore opens, or creates, the file at path and loads any existing
+// claims from it
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{mem: NewMemoryStore()}
+	lg, err := filelog.Open(path, func(line string) error {
+		return s.load(path, line)
+	}, s.snapshot)
+	if err != nil {
+		return nil, err
+	}
+	s.log = lg
+	return s, nil
+}
+
+// each line in the file is either "+ <key> <expires>" or "- <key>" where
+// expires is a unix timestamp, or 0 for never
+func (s *FileStore) load(path, line string) error {
+	parts := strings.Fields(line)
+	switch {
+	case len(parts) == 3 && parts[0] == "+":
+		ts, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("replay: invalid line in %s: %q", path, line)
+		}
+		var e time.Time
+		if ts > 0 {
+			e = time.Unix(ts, 0)
+		}
+		s.mem.keys[parts[1]] = e
+	case len(parts) == 2 && parts[0] == "-":
+		delete(s.mem.keys, parts[1])
+	default:
+		// a partially written last line is expected if we crashed
+		// mid-write so just skip it
+	}
+	return nil
+}
+
+// snapshot writes a line for every current claim when the file is compacted
+func (s *FileStore) snapshot(write func(string) error) error {
+	return s.mem.each(func(k string, e time.Time) error {
+		return write(claimLine(k, e))
+	})
+}
+
+func claimLine(key string, expires time.Time) string {
+	var ts int64
+	if !expires.IsZero() {
+		ts = expires.Unix()
+	}
+	return "+ " + key + " " + strconv.FormatInt(ts, 10) + "\n"
+}
+
+// Claim implements the Store interface
+func (s *FileStore) Claim(key string, expires time.Time) error {
+	return s.log.Append(claimLine(key, expires), func() error {
+		return s.mem.Claim(key, expires)
+	}, func() {
+		s.mem.Release(key)
+	})
+}
+
+// Release implements the Store interface
+func (s *FileStore) Release(key string) error {
+	return s.log.Append("- "+key+"\n", func() error {
+		return s.mem.Release(key)
+	}, nil)
+}
+
+// Used implements the Store interface
+func (s *FileStore) Used(key string) (bool, error) {
+	return s.mem.Used(key)
+}
+
+// Close closes the underlying file. The FileStore cannot be used afterwards
+func (s *FileStore) Close() error {
+	return s.log.Close()
+}
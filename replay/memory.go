@@ -0,0 +1,88 @@
+package replay
+
+import (
+	"sync"
+	"time"
+)
+
+// how often expired keys are removed from a MemoryStore
+var pruneInterval = time.Minute
+
+// MemoryStore is a Store that keeps all claims in memory. Claims are lost when
+// the process exits
+type MemoryStore struct {
+	l         sync.Mutex
+	keys      map[string]time.Time
+	lastPrune time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		keys:      map[string]time.Time{},
+		lastPrune: time.Now(),
+	}
+}
+
+func expired(expires, now time.Time) bool {
+	return !expires.IsZero() && !now.Before(expires)
+}
+
+// prune removes any expired keys. It must be called with the lock held
+func (s *MemoryStore) prune(now time.Time) {
+	if now.Sub(s.lastPrune) < pruneInterval {
+		return
+	}
+	for k, e := range s.keys {
+		if expired(e, now) {
+			delete(s.keys, k)
+		}
+	}
+	s.lastPrune = now
+}
+
+// Claim implements the Store interface
+func (s *MemoryStore) Claim(key string, expires time.Time) error {
+	now := time.Now()
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.prune(now)
+	if e, ok := s.keys[key]; ok && !expired(e, now) {
+		return ErrUsed
+	}
+	s.keys[key] = expires
+	return nil
+}
+
+// Release implements the Store interface
+func (s *MemoryStore) Release(key string) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	delete(s.keys, key)
+	return nil
+}
+
+// Used implements the Store interface
+func (s *MemoryStore) Used(key string) (bool, error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	e, ok := s.keys[key]
+	return ok && !expired(e, time.Now()), nil
+}
+
+// each calls fn for every key that hasn't expired. It's used by FileStore when
+// compacting
+func (s *MemoryStore) each(fn func(string, time.Time) error) error {
+	now := time.Now()
+	s.l.Lock()
+	defer s.l.Unlock()
+	for k, e := range s.keys {
+		if expired(e, now) {
+			continue
+		}
+		if err := fn(k, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+// Package replay provides stores used to remember which signatures have
+// already been consumed so that they can only be used once
+package replay
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrUsed is returned from Claim when the key has already been claimed
+var ErrUsed = errors.New("replay: key already used")
+
+// Store records keys that have been used. Implementations must be safe for
+// concurrent use
+type Store interface {
+	// Claim records the key as used until the expires time. If the key was
+	// already claimed and hasn't expired then ErrUsed is returned. A zero
+	// expires means the key never expires
+	Claim(key string, expires time.Time) error
+
+	// Release removes a previous claim so the key can be claimed again. This
+	// is used when whatever the key was claimed for fails
+	Release(key string) error
+
+	// Used returns true if the key is currently claimed
+	Used(key string) (bool, error)
+}
@@ -0,0 +1,78 @@
+package replay
+
+import (
+	. "testing"
+
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testStore(t *T, s Store) {
+	used, err := s.Used("a")
+	require.Nil(t, err)
+	assert.False(t, used)
+
+	require.Nil(t, s.Claim("a", time.Time{}))
+	assert.Equal(t, ErrUsed, s.Claim("a", time.Time{}))
+	used, err = s.Used("a")
+	require.Nil(t, err)
+	assert.True(t, used)
+
+	require.Nil(t, s.Release("a"))
+	require.Nil(t, s.Claim("a", time.Time{}))
+
+	// an expired claim can be claimed again
+	require.Nil(t, s.Claim("b", time.Now().Add(-time.Second)))
+	used, err = s.Used("b")
+	require.Nil(t, err)
+	assert.False(t, used)
+	require.Nil(t, s.Claim("b", time.Now().Add(time.Hour)))
+	assert.Equal(t, ErrUsed, s.Claim("b", time.Time{}))
+}
+
+func TestMemoryStore(t *T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestFileStore(t *T) {
+	dir, err := ioutil.TempDir("", "dank-replay")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "used")
+
+	s, err := NewFileStore(path)
+	require.Nil(t, err)
+	testStore(t, s)
+	require.Nil(t, s.Claim("c", time.Time{}))
+	require.Nil(t, s.Release("c"))
+	require.Nil(t, s.Close())
+
+	s, err = NewFileStore(path)
+	require.Nil(t, err)
+	for _, k := range []string{"a", "b"} {
+		used, err := s.Used(k)
+		require.Nil(t, err)
+		assert.True(t, used, k)
+	}
+	used, err := s.Used("c")
+	require.Nil(t, err)
+	assert.False(t, used)
+
+	// claims made after a compaction while running are kept too
+	s.log.CompactEvery = 1
+	require.Nil(t, s.Claim("d", time.Time{}))
+	require.Nil(t, s.Close())
+	s, err = NewFileStore(path)
+	require.Nil(t, err)
+	for _, k := range []string{"a", "b", "d"} {
+		used, err := s.Used(k)
+		require.Nil(t, err)
+		assert.True(t, used, k)
+	}
+	require.Nil(t, s.Close())
+}
@@ -0,0 +1,79 @@
+package seaweed
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Each volume server has a circuit breaker. After breakerThreshold failures in
+// a row the volume server is tried after any others that have the file, until
+// breakerCooldown has passed. Then it's tried normally again, but another
+// failure deprioritizes it right away
+var (
+	breakerThreshold = 3
+	breakerCooldown  = 30 * time.Second
+)
+
+type breaker struct {
+	failures  int
+	openUntil time.Time
+}
+
+// breakerSet holds the breaker of every volume server that has failed
+type breakerSet struct {
+	sync.Mutex
+	m map[string]*breaker
+}
+
+func newBreakerSet() *breakerSet {
+	return &breakerSet{
+		m: map[string]*breaker{},
+	}
+}
+
+// failed records a connection error or 5xx from the volume server
+func (bs *breakerSet) failed(loc string) {
+	bs.Lock()
+	defer bs.Unlock()
+	b, ok := bs.m[loc]
+	if !ok {
+		b = &breaker{}
+		bs.m[loc] = b
+	}
+	if b.failures++; b.failures >= breakerThreshold {
+		b.openUntil = time.Now().Add(breakerCooldown)
+	}
+}
+
+// succeeded records a successful response from the volume server
+func (bs *breakerSet) succeeded(loc string) {
+	bs.Lock()
+	defer bs.Unlock()
+	delete(bs.m, loc)
+}
+
+// healthy returns false if the volume server's breaker is open
+func (bs *breakerSet) healthy(loc string) bool {
+	bs.Lock()
+	defer bs.Unlock()
+	b, ok := bs.m[loc]
+	return !ok || !time.Now().Before(b.openUntil)
+}
+
+// order returns the locations in a random order, to spread reads
+// across the replicas, but with any unhealthy volume servers last. They're
+// still returned since they're better than nothing if every location is
+// unhealthy
+func (bs *breakerSet) order(locs []string) []string {
+	healthy := make([]string, 0, len(locs))
+	var unhealthy []string
+	for _, i := range rand.Perm(len(locs)) {
+		if bs.healthy(locs[i]) {
+			healthy = append(healthy, locs[i])
+		} else {
+			unhealthy = append(unhealthy, locs[i])
+		}
+	}
+	return append(healthy, unhealthy...)
+}
@@ -0,0 +1,64 @@
+package seaweed
+
+import (
+	. "testing"
+
+	"github.com/levenlabs/dank/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+)
+
+func TestOrderLocations(t *T) {
+	bs := newBreakerSet()
+	for i := 0; i < breakerThreshold; i++ {
+		bs.failed("a:8080")
+	}
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, "a:8080", bs.order([]string{"a:8080", "b:8080", "c:8080"})[2])
+	}
+
+	// once the cooldown passes it's tried normally again
+	bs.m["a:8080"].openUntil = time.Now()
+	assert.True(t, bs.healthy("a:8080"))
+}
+
+func TestGetFailover(t *T) {
+	cfg := config.New()
+	cfg.LookupCacheTTL = time.Hour
+	cfg.LookupCacheSize = 10
+	c := NewClient(cfg)
+
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer down.Close()
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer up.Close()
+	downLoc := strings.TrimPrefix(down.URL, "http://")
+	upLoc := strings.TrimPrefix(up.URL, "http://")
+
+	f := encoder.EncodeToString([]byte("7,01637037d6"))
+	c.cache.set("7", []string{downLoc, upLoc})
+
+	// whichever order they're tried in the file is returned
+	for i := 0; i < 5; i++ {
+		body, _, err := c.Get(f, nil, nil)
+		require.Nil(t, err)
+		b, err := ioutil.ReadAll(body)
+		body.Close()
+		require.Nil(t, err)
+		assert.Equal(t, "hello", string(b))
+	}
+
+	c.cache.set("7", []string{downLoc})
+	_, _, err := c.Get(f, nil, nil)
+	assert.NotNil(t, err)
+	assert.True(t, c.breakers.m[downLoc].failures > 0)
+}
@@ -0,0 +1,107 @@
+package seaweed
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The locations of volumes are cached so every read doesn't need a lookup on
+// the master. Volumes rarely move, and when they do the volume server that no
+// longer has it returns a 404 or can't be reached, which removes the volume
+// from the cache so the next read looks it up again
+
+type cacheEntry struct {
+	volumeID  string
+	locations []string
+	expires   time.Time
+}
+
+// volumeCache is a LRU cache of volume id to the locations of that volume.
+// Entries expire after ttl and at most size volumes are cached
+type volumeCache struct {
+	sync.Mutex
+	l    *list.List
+	m    map[string]*list.Element
+	ttl  time.Duration
+	size int
+}
+
+func newVolumeCache(ttl time.Duration, size int) *volumeCache {
+	return &volumeCache{
+		l:    list.New(),
+		m:    map[string]*list.Element{},
+		ttl:  ttl,
+		size: size,
+	}
+}
+
+// get returns the cached locations of the volume or nil if they're not cached
+// or expired
+func (c *volumeCache) get(volumeID string) []string {
+	c.Lock()
+	defer c.Unlock()
+	el, ok := c.m[volumeID]
+	if !ok {
+		return nil
+	}
+	e := el.Value.(*cacheEntry)
+	if time.Now().After(e.expires) {
+		c.l.Remove(el)
+		delete(c.m, volumeID)
+		return nil
+	}
+	c.l.MoveToFront(el)
+	return e.locations
+}
+
+// set caches the locations of the volume for the cache's ttl. Nothing is cached
+// if the ttl or size is 0
+func (c *volumeCache) set(volumeID string, locations []string) {
+	if c.ttl <= 0 || c.size <= 0 {
+		return
+	}
+	e := &cacheEntry{
+		volumeID:  volumeID,
+		locations: locations,
+		expires:   time.Now().Add(c.ttl),
+	}
+	c.Lock()
+	defer c.Unlock()
+	if el, ok := c.m[volumeID]; ok {
+		el.Value = e
+		c.l.MoveToFront(el)
+		return
+	}
+	c.m[volumeID] = c.l.PushFront(e)
+	for c.l.Len() > c.size {
+		el := c.l.Back()
+		c.l.Remove(el)
+		delete(c.m, el.Value.(*cacheEntry).volumeID)
+	}
+}
+
+// remove removes the volume from the cache
+func (c *volumeCache) remove(volumeID string) {
+	c.Lock()
+	defer c.Unlock()
+	if el, ok := c.m[volumeID]; ok {
+		c.l.Remove(el)
+		delete(c.m, volumeID)
+	}
+}
+
+// volumeID returns the id of the volume a fid is in
+func volumeID(fid string) string {
+	return strings.SplitN(fid, ",", 2)[0]
+}
+
+// forgetVolume removes the volume the filename is in from the cache. It's
+// called when a volume server didn't have a file or couldn't be reached since
+// the volume might have moved
+func (c *Client) forgetVolume(filename string) {
+	if fid, err := decodeFilename(filename); err == nil {
+		c.cache.remove(volumeID(fid))
+	}
+}
@@ -0,0 +1,65 @@
+package seaweed
+
+import (
+	. "testing"
+
+	"github.com/levenlabs/dank/config"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+)
+
+func TestVolumeCache(t *T) {
+	c := newVolumeCache(time.Hour, 2)
+	c.set("1", []string{"a:8080"})
+	c.set("2", []string{"b:8080"})
+	assert.Equal(t, []string{"a:8080"}, c.get("1"))
+	// 2 is now the least recently used
+	c.set("3", []string{"c:8080"})
+	assert.Nil(t, c.get("2"))
+	assert.Equal(t, []string{"a:8080"}, c.get("1"))
+	assert.Equal(t, []string{"c:8080"}, c.get("3"))
+
+	c.remove("1")
+	assert.Nil(t, c.get("1"))
+
+	c.ttl = time.Nanosecond
+	c.set("4", []string{"d:8080"})
+	time.Sleep(time.Millisecond)
+	assert.Nil(t, c.get("4"))
+
+	c.ttl = 0
+	c.set("5", []string{"e:8080"})
+	assert.Nil(t, c.get("5"))
+}
+
+func TestLookupCached(t *T) {
+	cfg := config.New()
+	cfg.LookupCacheTTL = time.Hour
+	cfg.LookupCacheSize = 10
+
+	var lookups int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lookups++
+		assert.Equal(t, "3", r.URL.Query().Get("volumeId"))
+		w.Write([]byte(`{"locations":[{"url":"127.0.0.1:8080"}]}`))
+	}))
+	defer srv.Close()
+	cfg.SeaweedAddr = strings.TrimPrefix(srv.URL, "http://")
+	c := NewClient(cfg)
+
+	f := encoder.EncodeToString([]byte("3,01637037d6"))
+	for i := 0; i < 3; i++ {
+		u, err := c.Lookup(f, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, "http://127.0.0.1:8080/3,01637037d6", u)
+	}
+	assert.Equal(t, 1, lookups)
+
+	c.forgetVolume(f)
+	_, err := c.Lookup(f, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, lookups)
+}
@@ -0,0 +1,75 @@
+package seaweed
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/levenlabs/dank/metrics"
+	"github.com/levenlabs/go-llog"
+	"github.com/levenlabs/go-srvclient"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type clusterStatus struct {
+	IsLeader bool   `json:"IsLeader"`
+	Leader   string `json:"Leader"`
+}
+
+// Ping checks that the seaweed master can be reached within timeout and that
+// the cluster has a leader, which is needed to assign and look up files
+func (c *Client) Ping(timeout time.Duration) (err error) {
+	defer metrics.ObserveSeaweed("ping", time.Now(), &err)
+	addr := srvclient.MaybeSRV(c.cfg.SeaweedAddr)
+	uStr := "http://" + addr + "/cluster/status"
+	kv := llog.KV{
+		"url": uStr,
+	}
+	llog.Debug("making seaweed GET request", kv)
+
+	hc := &http.Client{Timeout: timeout}
+	resp, err := hc.Get(uStr)
+	if err != nil {
+		kv["error"] = err
+		llog.Warn("error making seaweed http request", kv)
+		return err
+	}
+	if _, err = handleResp(resp, kv, http.StatusOK); err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	s := &clusterStatus{}
+	if err = json.NewDecoder(resp.Body).Decode(s); err != nil {
+		kv["error"] = err
+		llog.Warn("error decoding cluster status from seaweed", kv)
+		return err
+	}
+	if !s.IsLeader && s.Leader == "" {
+		llog.Warn("seaweed cluster has no leader", kv)
+		return errors.New("seaweed cluster has no leader")
+	}
+	return nil
+}
+
+// readyState is the last result of Ready
+type readyState struct {
+	sync.Mutex
+	checked time.Time
+	err     error
+}
+
+// Ready returns the result of Ping using the HealthTimeout of the Config. The
+// result is cached for its HealthCacheTTL so frequent probes don't each hit
+// seaweed
+func (c *Client) Ready() error {
+	c.ready.Lock()
+	defer c.ready.Unlock()
+	if !c.ready.checked.IsZero() && time.Since(c.ready.checked) < c.cfg.HealthCacheTTL {
+		return c.ready.err
+	}
+	// hold the lock while pinging so concurrent probes share one request
+	c.ready.err = c.Ping(c.cfg.HealthTimeout)
+	c.ready.checked = time.Now()
+	return c.ready.err
+}
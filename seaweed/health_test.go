@@ -0,0 +1,48 @@
+package seaweed
+
+import (
+	. "testing"
+
+	"github.com/levenlabs/dank/config"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+)
+
+func TestReady(t *T) {
+	status := `{"IsLeader":true,"Leader":"127.0.0.1:9333"}`
+	var hits int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		assert.Equal(t, "/cluster/status", r.URL.Path)
+		if status == "" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(status))
+	}))
+	defer srv.Close()
+	cfg := config.New()
+	cfg.SeaweedAddr = strings.TrimPrefix(srv.URL, "http://")
+	cfg.HealthCacheTTL = time.Hour
+	c := NewClient(cfg)
+
+	assert.Nil(t, c.Ping(time.Second))
+	status = `{"IsLeader":false,"Leader":""}`
+	assert.NotNil(t, c.Ping(time.Second))
+	status = ""
+	assert.NotNil(t, c.Ping(time.Second))
+
+	// the first result is reused until it expires
+	hits = 0
+	assert.NotNil(t, c.Ready())
+	status = `{"IsLeader":true,"Leader":"127.0.0.1:9333"}`
+	assert.NotNil(t, c.Ready())
+	assert.Equal(t, 1, hits)
+
+	cfg.HealthCacheTTL = 0
+	assert.Nil(t, c.Ready())
+	assert.Equal(t, 2, hits)
+}
@@ -1,7 +1,13 @@
 package seaweed
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
 	"strings"
 )
 
@@ -70,3 +76,40 @@ func NewRawAssignResult(u, fid string) *AssignResult {
 		url: u,
 	}
 }
+
+// FormatFID returns the fid of the needle with the given key and cookie in the
+// volume, formatted the way seaweed formats them. The key and cookie are hex
+// encoded together with the key's leading zero bytes removed
+func FormatFID(volume uint32, key uint64, cookie uint32) string {
+	var b [12]byte
+	binary.BigEndian.PutUint64(b[:8], key)
+	binary.BigEndian.PutUint32(b[8:], cookie)
+	i := 0
+	for i < 8 && b[i] == 0 {
+		i++
+	}
+	return strconv.FormatUint(uint64(volume), 10) + "," + hex.EncodeToString(b[i:])
+}
+
+// DerivedFilename returns a filename, with the extension ext, in the same
+// volume as filename which is unique to the given key. It's used to store
+// files generated from another file, like a resized image, so they can be
+// found again without storing where they are.
+//
+// The needle key always has its high bit set so that it won't collide with the
+// keys seaweed hands out, which count up from 1
+func DerivedFilename(filename, key, ext string) (string, error) {
+	fid, err := decodeFilename(filename)
+	if err != nil {
+		return "", err
+	}
+	parts := strings.SplitN(fid, ",", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", errors.New("invalid fid")
+	}
+	h := sha256.Sum256([]byte(fid + "\x00" + key))
+	nkey := binary.BigEndian.Uint64(h[:8]) | 1<<63
+	cookie := binary.BigEndian.Uint32(h[8:12])
+	dfid := fmt.Sprintf("%s,%x%08x", parts[0], nkey, cookie)
+	return encoder.EncodeToString([]byte(dfid)) + ext, nil
+}
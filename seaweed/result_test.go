@@ -0,0 +1,46 @@
+package seaweed
+
+import (
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+)
+
+func TestDerivedFilename(t *T) {
+	f := encoder.EncodeToString([]byte("3,01637037d6")) + ".jpg"
+	d, err := DerivedFilename(f, "w=100", ".png")
+	require.Nil(t, err)
+	assert.True(t, strings.HasSuffix(d, ".png"))
+
+	fid, err := decodeFilename(d)
+	require.Nil(t, err)
+	assert.True(t, strings.HasPrefix(fid, "3,"))
+	// 16 hex characters of key with the high bit set and 8 of cookie
+	assert.Len(t, fid, 2+16+8)
+	assert.Contains(t, "89abcdef", fid[2:3])
+
+	d2, err := DerivedFilename(f, "w=100", ".png")
+	require.Nil(t, err)
+	assert.Equal(t, d, d2)
+	d2, err = DerivedFilename(f, "w=200", ".png")
+	require.Nil(t, err)
+	assert.NotEqual(t, d, d2)
+
+	_, err = DerivedFilename("!!!", "w=100", "")
+	assert.NotNil(t, err)
+}
+
+func TestRawAssignResult(t *T) {
+	r := &rawAssignResult{FID: "3,01637037d6", URL: "127.0.0.1:8080", Count: 3}
+	assert.Equal(t, "3,01637037d6", r.assignResult(0).FID())
+	assert.Equal(t, "3,01637037d6_2", r.assignResult(2).FID())
+	assert.Equal(t, "127.0.0.1:8080", r.assignResult(2).Host())
+}
+
+func TestFormatFID(t *T) {
+	assert.Equal(t, "3,01637037d6", FormatFID(3, 1, 0x637037d6))
+	assert.Equal(t, "3,0163637037d6", FormatFID(3, 0x163, 0x637037d6))
+	assert.Equal(t, "12,8000000000000001000000ff", FormatFID(12, 1<<63|1, 0xff))
+}
@@ -1,12 +1,13 @@
 package seaweed
 
 import (
-	"bytes"
+	"bufio"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/levenlabs/dank/config"
 	dhttp "github.com/levenlabs/dank/http"
+	"github.com/levenlabs/dank/metrics"
 	"github.com/levenlabs/go-llog"
 	"github.com/levenlabs/go-srvclient"
 	"io"
@@ -18,15 +19,15 @@ import (
 	"net/url"
 	"path/filepath"
 	"strconv"
-	"strings"
 	"time"
 )
 
 // rawAssignResult is only used to Unmarshal into and then an AssignResult is
 // made to publicly return
 type rawAssignResult struct {
-	FID string `json:"fid"`
-	URL string `json:"url"`
+	FID   string `json:"fid"`
+	URL   string `json:"url"`
+	Count int    `json:"count"`
 }
 
 type lookupResult struct {
@@ -38,15 +39,36 @@ type location struct {
 }
 
 func init() {
-	if config.SeaweedAddr == "" {
-		llog.Fatal("--seaweed-addr is required")
-	}
 	rand.Seed(time.Now().UnixNano())
 }
 
-// assignResult returns a public AssignResult from a rawAssignResult
-func (r *rawAssignResult) assignResult() *AssignResult {
-	return NewRawAssignResult(r.URL, r.FID)
+// Client talks to the seaweed cluster whose master is at the SeaweedAddr of
+// its Config. It caches the locations of volumes and tracks which volume
+// servers are failing, so a single Client should be shared
+type Client struct {
+	cfg      *config.Config
+	cache    *volumeCache
+	breakers *breakerSet
+	ready    readyState
+}
+
+// NewClient returns a Client using the seaweed settings in cfg
+func NewClient(cfg *config.Config) *Client {
+	return &Client{
+		cfg:      cfg,
+		cache:    newVolumeCache(cfg.LookupCacheTTL, cfg.LookupCacheSize),
+		breakers: newBreakerSet(),
+	}
+}
+
+// assignResult returns a public AssignResult from a rawAssignResult. i is the
+// index of the file when multiple were assigned at once
+func (r *rawAssignResult) assignResult(i int) *AssignResult {
+	fid := r.FID
+	if i > 0 {
+		fid += "_" + strconv.Itoa(i)
+	}
+	return NewRawAssignResult(r.URL, fid)
 }
 
 // intInList determines if the int i is in the list l
@@ -99,8 +121,23 @@ func handleResp(resp *http.Response, kv llog.KV, expectedCodes ...int) (int, err
 // to and returns an AssignResult. Optionally replication can be sent to
 // guarantee the replication of the file and ttl can be sent to expire the file
 // after a specific amount of time. See the seaweedfs docs.
-func Assign(replication, ttl string) (*AssignResult, error) {
-	addr := srvclient.MaybeSRV(config.SeaweedAddr)
+func (c *Client) Assign(replication, ttl string) (*AssignResult, error) {
+	rs, err := c.AssignN(replication, ttl, 1)
+	if err != nil {
+		return nil, err
+	}
+	return rs[0], nil
+}
+
+// AssignN is like Assign but reserves count filenames with a single call to
+// seaweed. Seaweed returns one fid and the rest are that fid with _1, _2, etc
+// appended, which all live on the same volume
+func (c *Client) AssignN(replication, ttl string, count int) (_ []*AssignResult, err error) {
+	defer metrics.ObserveSeaweed("assign", time.Now(), &err)
+	if count < 1 {
+		count = 1
+	}
+	addr := srvclient.MaybeSRV(c.cfg.SeaweedAddr)
 	uStr := "http://" + addr + "/dir/assign"
 	u, err := url.Parse(uStr)
 	if err != nil {
@@ -116,6 +153,9 @@ func Assign(replication, ttl string) (*AssignResult, error) {
 	if ttl != "" {
 		q.Set("ttl", ttl)
 	}
+	if count > 1 {
+		q.Set("count", strconv.Itoa(count))
+	}
 	u.RawQuery = q.Encode()
 	uStr = u.String()
 
@@ -142,7 +182,18 @@ func Assign(replication, ttl string) (*AssignResult, error) {
 		llog.Error("error decoding assign response from seaweed", kv)
 		return nil, err
 	}
-	return r.assignResult(), nil
+	// if seaweed didn't reserve them all then the extra fids could be handed
+	// out again by a later assign
+	if count > 1 && r.Count < count {
+		kv["count"] = r.Count
+		llog.Error("seaweed assigned fewer files than requested", kv)
+		return nil, errors.New("seaweed assigned fewer files than requested")
+	}
+	rs := make([]*AssignResult, count)
+	for i := range rs {
+		rs[i] = r.assignResult(i)
+	}
+	return rs, nil
 }
 
 // createFormFile is multipart.Writer.CreateFormFile but it accepts mimetype
@@ -161,7 +212,11 @@ func createFormFile(w *multipart.Writer, fieldname, filename, ct string) (io.Wri
 // Upload takes an existing AssignResult call that has already been validated
 // and a io.Reader body. It uploads the body to the sent seaweed volume and
 // fid. Optionally it passes along a ttl to seaweed.
-func Upload(r *AssignResult, body io.Reader, ct string, urlParams map[string]string) error {
+//
+// The body is streamed to seaweed using chunked transfer encoding so it's
+// never held in memory
+func (c *Client) Upload(r *AssignResult, body io.Reader, ct string, urlParams map[string]string) (err error) {
+	defer metrics.ObserveSeaweed("upload", time.Now(), &err)
 	u, err := url.Parse(r.URL())
 	if err != nil {
 		llog.Error("error building seaweed url", llog.KV{
@@ -182,43 +237,61 @@ func Upload(r *AssignResult, body io.Reader, ct string, urlParams map[string]str
 	}
 	llog.Debug("making seaweed PUT request", kv)
 
-	// we HAVE to upload a form the file in file
-	newBody := &bytes.Buffer{}
-	mpw := multipart.NewWriter(newBody)
-	part, err := createFormFile(mpw, "file", r.Filename(), ct)
-	if err != nil {
-		kv["error"] = err
-		kv["filename"] = r.Filename()
-		llog.Error("error creating multipart file", kv)
-		return err
-	}
-	nb, err := io.Copy(part, body)
-	if err != nil {
-		kv["error"] = err
-		llog.Error("error copying body to multipart", kv)
-		return err
-	}
-	if nb < 1 {
-		llog.Error("empty body encountered", kv)
-		return err
-	}
-	err = mpw.Close()
-	if err != nil {
+	// make sure there's something to upload before making the request
+	br := bufio.NewReader(body)
+	if _, err = br.Peek(1); err == io.EOF {
+		llog.Warn("empty body encountered", kv)
+		return dhttp.NewError(http.StatusBadRequest, "empty body uploaded").WithReason("empty")
+	} else if err != nil {
 		kv["error"] = err
-		llog.Error("error closing multipart writer", kv)
+		llog.Error("error reading body", kv)
 		return err
 	}
 
-	req, err := http.NewRequest("PUT", uStr, newBody)
+	// we HAVE to upload a form the file in file
+	pr, pw := io.Pipe()
+	mpw := multipart.NewWriter(pw)
+	copyErr := make(chan error, 1)
+	go func() {
+		part, err := createFormFile(mpw, "file", r.Filename(), ct)
+		if err == nil {
+			_, err = io.Copy(part, br)
+		}
+		if err == nil {
+			err = mpw.Close()
+		}
+		// a nil error closes the pipe normally
+		pw.CloseWithError(err)
+		copyErr <- err
+	}()
+
+	req, err := http.NewRequest("PUT", uStr, pr)
 	if err != nil {
+		pr.Close()
+		<-copyErr
 		kv["error"] = err
 		llog.Warn("error making seaweed http request", kv)
 		return err
 	}
+	// unknown length means chunked
+	req.ContentLength = -1
 	req.Header.Add("Content-Type", mpw.FormDataContentType())
-	var resp *http.Response
-	var code int
-	if resp, code, err = doReq(req, kv, http.StatusCreated); err != nil {
+	resp, code, err := doReq(req, kv, http.StatusCreated)
+	// the transport closes the body when it's done but make sure the copying
+	// stops either way before checking its error
+	pr.Close()
+	if cerr := <-copyErr; cerr != nil && cerr != io.ErrClosedPipe {
+		kv["error"] = cerr
+		llog.Error("error copying body to multipart", kv)
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return cerr
+	}
+	if err != nil {
+		if code == 0 || code == http.StatusNotFound {
+			c.forgetVolume(r.Filename())
+		}
 		if code == http.StatusNotFound {
 			err = dhttp.NewError(code, "filename not found: %s", r.Filename())
 		}
@@ -229,7 +302,51 @@ func Upload(r *AssignResult, body io.Reader, ct string, urlParams map[string]str
 }
 
 // Lookup takes a filename and returns the seaweed url needed to get that file
-func Lookup(filename string, urlParams map[string]string) (string, error) {
+func (c *Client) Lookup(filename string, urlParams map[string]string) (string, error) {
+	fid, locs, err := c.lookupLocations(filename)
+	if err != nil {
+		return "", err
+	}
+	return fileURL(c.breakers.order(locs)[0], fid, filename, urlParams)
+}
+
+// fileURL returns the url of the fid on the volume server at loc with the
+// extension of filename and the url params
+func fileURL(loc, fid, filename string, urlParams map[string]string) (string, error) {
+	uStr := NewRawAssignResult(loc, fid).URL() + filepath.Ext(filename)
+
+	if len(urlParams) > 0 {
+		u, err := url.Parse(uStr)
+		if err != nil {
+			llog.Error("error building seaweed url", llog.KV{
+				"url": uStr,
+			})
+			return "", err
+		}
+		vals := u.Query()
+		for k, v := range urlParams {
+			vals.Set(k, v)
+		}
+		u.RawQuery = vals.Encode()
+		uStr = u.String()
+	}
+
+	return uStr, nil
+}
+
+// lookupVolume takes a filename and returns an AssignResult with the host of
+// one of the volumes that has the file
+func (c *Client) lookupVolume(filename string) (*AssignResult, error) {
+	fid, locs, err := c.lookupLocations(filename)
+	if err != nil {
+		return nil, err
+	}
+	return NewRawAssignResult(c.breakers.order(locs)[0], fid), nil
+}
+
+// lookupLocations takes a filename and returns its fid and the host of every
+// volume server that has the file
+func (c *Client) lookupLocations(filename string) (_ string, _ []string, err error) {
 	ar, err := NewAssignResult("", filename)
 	if err != nil {
 		llog.Warn("error decoding filename in lookup", llog.KV{
@@ -238,12 +355,16 @@ func Lookup(filename string, urlParams map[string]string) (string, error) {
 		})
 		err = dhttp.NewError(http.StatusBadRequest,
 			"invalid filename sent: %s", filename)
-		return "", err
+		return "", nil, err
+	}
+	vid := volumeID(ar.FID())
+	if locs := c.cache.get(vid); len(locs) > 0 {
+		return ar.FID(), locs, nil
 	}
-	//fid's format is volumeId,somestuff
-	parts := strings.Split(ar.FID(), ",")
-	addr := srvclient.MaybeSRV(config.SeaweedAddr)
-	uStr := "http://" + addr + "/dir/lookup?volumeId=" + parts[0]
+
+	defer metrics.ObserveSeaweed("lookup", time.Now(), &err)
+	addr := srvclient.MaybeSRV(c.cfg.SeaweedAddr)
+	uStr := "http://" + addr + "/dir/lookup?volumeId=" + vid
 
 	kv := llog.KV{
 		"url":  uStr,
@@ -255,13 +376,13 @@ func Lookup(filename string, urlParams map[string]string) (string, error) {
 	if err != nil {
 		kv["error"] = err
 		llog.Warn("error making seaweed http request", kv)
-		return "", err
+		return "", nil, err
 	}
 	if code, err := handleResp(resp, kv, http.StatusOK); err != nil {
 		if code == http.StatusNotFound {
 			err = dhttp.NewError(code, "filename not found: %s", filename)
 		}
-		return "", err
+		return "", nil, err
 	}
 	defer resp.Body.Close()
 
@@ -270,98 +391,169 @@ func Lookup(filename string, urlParams map[string]string) (string, error) {
 	if err != nil {
 		kv["error"] = err
 		llog.Error("error decoding get response from seaweed", kv)
-		return "", err
+		return "", nil, err
 	}
 	if len(r.Locations) == 0 {
 		err = dhttp.NewError(http.StatusNotFound,
 			"filname not found: %s", filename)
-		return "", err
+		return "", nil, err
 	}
-	i := rand.Intn(len(r.Locations))
-	u := r.Locations[i].URL
-	uStr = "http://" + u + "/" + ar.FID() + filepath.Ext(filename)
-
-	if len(urlParams) > 0 {
-		u, err := url.Parse(uStr)
-		if err != nil {
-			llog.Error("error building seaweed url", llog.KV{
-				"url": uStr,
-			})
-			return "", err
-		}
-		vals := u.Query()
-		for k, v := range urlParams {
-			vals.Set(k, v)
-		}
-		u.RawQuery = vals.Encode()
-		uStr = u.String()
+	locs := make([]string, len(r.Locations))
+	for i := range r.Locations {
+		locs[i] = r.Locations[i].URL
 	}
-
-	return uStr, nil
+	c.cache.set(vid, locs)
+	return ar.FID(), locs, nil
 }
 
-// Get takes the given filename, gets the file from seaweed, returns an
-// io.Reader you must close this io.Reader. The io.Reader might be nil if no
-// response was returned or there was an error.
-// You can also include headers HTTP headers to send along with the request
-// and url params
-func Get(filename string, headers, urlParams map[string]string) (io.ReadCloser, *http.Header, error) {
-	uStr, err := Lookup(filename, urlParams)
+// Put uploads body to the given filename without an assign call. This is only
+// meant for files dank derives from others, like with DerivedFilename, since
+// the fid was not handed out by seaweed
+func (c *Client) Put(filename string, body io.Reader, ct string, urlParams map[string]string) error {
+	ar, err := c.lookupVolume(filename)
 	if err != nil {
-		return nil, nil, err
+		return err
 	}
+	return c.Upload(ar, body, ct, urlParams)
+}
 
+// Exists returns whether the given filename exists in seaweed
+func (c *Client) Exists(filename string) (_ bool, err error) {
+	defer metrics.ObserveSeaweed("exists", time.Now(), &err)
+	uStr, err := c.Lookup(filename, nil)
+	if err != nil {
+		return false, err
+	}
 	kv := llog.KV{
 		"url":      uStr,
 		"filename": filename,
 	}
-	llog.Debug("making seaweed GET request", kv)
+	llog.Debug("making seaweed HEAD request", kv)
 
-	resp, err := http.Get(uStr)
+	resp, err := http.Head(uStr)
 	if err != nil {
 		kv["error"] = err
 		llog.Warn("error making seaweed http request", kv)
+		// a 404 isn't used here since derived files are often checked
+		// before they exist
+		c.forgetVolume(filename)
+		return false, err
+	}
+	code, err := handleResp(resp, kv, http.StatusOK, http.StatusNotFound)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return code == http.StatusOK, nil
+}
+
+// tryLocations makes a request with method and headers for filename to each
+// volume server that has the file, in the order given by the breakers, until
+// one responds without a connection error or 5xx. If they all fail the last
+// error is returned, and if none could be reached the volume is removed from
+// the cache in case it moved
+func (c *Client) tryLocations(method, filename string, headers, urlParams map[string]string) (*http.Response, error) {
+	fid, locs, err := c.lookupLocations(filename)
+	if err != nil {
+		return nil, err
+	}
+	var reached bool
+	var lastErr error
+	for _, loc := range c.breakers.order(locs) {
+		uStr, err := fileURL(loc, fid, filename, urlParams)
+		if err != nil {
+			return nil, err
+		}
+		kv := llog.KV{
+			"url":      uStr,
+			"filename": filename,
+		}
+		llog.Debug("making seaweed "+method+" request", kv)
+
+		req, err := http.NewRequest(method, uStr, nil)
+		if err != nil {
+			kv["error"] = err
+			llog.Warn("error making seaweed http request", kv)
+			return nil, err
+		}
+		for n, v := range headers {
+			req.Header.Set(n, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil && resp.StatusCode < 500 {
+			c.breakers.succeeded(loc)
+			return resp, nil
+		}
+		c.breakers.failed(loc)
+		if err == nil {
+			reached = true
+			// no codes are expected so this logs and closes the body
+			_, err = handleResp(resp, kv)
+		} else {
+			kv["error"] = err
+			llog.Warn("error making seaweed http request", kv)
+		}
+		lastErr = err
+	}
+	if !reached {
+		c.forgetVolume(filename)
+	}
+	return nil, lastErr
+}
+
+// Get takes the given filename, gets the file from seaweed, returns an
+// io.Reader you must close this io.Reader. The io.Reader might be nil if no
+// response was returned or there was an error.
+// You can also include headers HTTP headers to send along with the request
+// and url params. The io.Reader is also nil if the file wasn't modified since
+// the If-Modified-Since header, and if the Range header was satisfied the
+// returned headers include Content-Range. If a volume server that has the
+// file can't be reached or returns a 5xx, the other volume servers that have
+// it are tried
+func (c *Client) Get(filename string, headers, urlParams map[string]string) (_ io.ReadCloser, _ *http.Header, err error) {
+	defer metrics.ObserveSeaweed("get", time.Now(), &err)
+	resp, err := c.tryLocations("GET", filename, headers, urlParams)
+	if err != nil {
 		return nil, nil, err
 	}
-	for n, v := range headers {
-		resp.Header.Set(n, v)
+	kv := llog.KV{
+		"url":      resp.Request.URL.String(),
+		"filename": filename,
 	}
-	if code, err := handleResp(resp, kv, http.StatusOK, http.StatusNotModified, http.StatusRequestedRangeNotSatisfiable); err != nil {
+	if code, err := handleResp(resp, kv, http.StatusOK, http.StatusPartialContent, http.StatusNotModified); err != nil {
 		if code == http.StatusNotFound {
+			c.forgetVolume(filename)
 			err = dhttp.NewError(code, "filename not found: %s", filename)
+		} else if code == http.StatusRequestedRangeNotSatisfiable {
+			err = dhttp.NewError(code, "range not satisfiable: %s", headers["Range"])
 		}
 		return nil, &resp.Header, err
 	}
 
 	var r io.ReadCloser
-	if resp.StatusCode == http.StatusOK {
+	if resp.StatusCode != http.StatusNotModified {
 		r = resp.Body
+	} else {
+		resp.Body.Close()
 	}
 	return r, &resp.Header, err
 }
 
-// Delete takes the given filename and deletes it from seaweed
-func Delete(filename string) error {
-	uStr, err := Lookup(filename, nil)
+// Delete takes the given filename and deletes it from seaweed. Like Get, the
+// other volume servers that have the file are tried if one fails
+func (c *Client) Delete(filename string) (err error) {
+	defer metrics.ObserveSeaweed("delete", time.Now(), &err)
+	resp, err := c.tryLocations("DELETE", filename, nil, nil)
 	if err != nil {
 		return err
 	}
 	kv := llog.KV{
-		"url":      uStr,
+		"url":      resp.Request.URL.String(),
 		"filename": filename,
 	}
-	llog.Debug("making seaweed DELETE request", kv)
-
-	req, err := http.NewRequest("DELETE", uStr, nil)
-	if err != nil {
-		kv["error"] = err
-		llog.Warn("error making seaweed http request", kv)
-		return err
-	}
-	var resp *http.Response
-	var code int
-	if resp, code, err = doReq(req, kv, http.StatusAccepted); err != nil {
+	if code, err := handleResp(resp, kv, http.StatusAccepted); err != nil {
 		if code == http.StatusNotFound {
+			c.forgetVolume(filename)
 			err = dhttp.NewError(code, "filename not found: %s", filename)
 		}
 		return err
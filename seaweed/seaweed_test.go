@@ -0,0 +1,116 @@
+package seaweed
+
+import (
+	. "testing"
+
+	"bytes"
+	"github.com/levenlabs/dank/config"
+	"github.com/levenlabs/dank/seaweed/seaweedtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func testServer(t *T) (*seaweedtest.Server, *Client) {
+	s := seaweedtest.NewServer()
+	cfg := config.New()
+	cfg.SeaweedAddr = s.Addr()
+	return s, NewClient(cfg)
+}
+
+func TestSeaweed(t *T) {
+	s, c := testServer(t)
+	defer s.Close()
+
+	ar, err := c.Assign("", "")
+	require.Nil(t, err)
+	f := ar.Filename() + ".txt"
+	ok, err := c.Exists(f)
+	require.Nil(t, err)
+	assert.False(t, ok)
+
+	err = c.Upload(ar, bytes.NewBufferString("hello"), "text/plain", map[string]string{"ts": "1476719000"})
+	require.Nil(t, err)
+	assert.Equal(t, 1, s.Len())
+	ok, err = c.Exists(f)
+	require.Nil(t, err)
+	assert.True(t, ok)
+
+	body, h, err := c.Get(f, nil, nil)
+	require.Nil(t, err)
+	b, err := ioutil.ReadAll(body)
+	body.Close()
+	require.Nil(t, err)
+	assert.Equal(t, "hello", string(b))
+	assert.Equal(t, "text/plain", h.Get("Content-Type"))
+	assert.Equal(t, time.Unix(1476719000, 0).UTC().Format(http.TimeFormat), h.Get("Last-Modified"))
+
+	// a different cookie is a different file as far as anyone is concerned
+	other := encoder.EncodeToString([]byte(ar.FID()[:len(ar.FID())-8] + "00000000"))
+	_, _, err = c.Get(other, nil, nil)
+	assert.NotNil(t, err)
+	assert.NotNil(t, c.Put(other, bytes.NewBufferString("bye"), "", nil))
+
+	require.Nil(t, c.Delete(f))
+	assert.Equal(t, 0, s.Len())
+	_, _, err = c.Get(f, nil, nil)
+	assert.NotNil(t, err)
+	assert.NotNil(t, c.Delete(f))
+
+	// an empty body is rejected before seaweed is called
+	assert.NotNil(t, c.Upload(ar, &bytes.Buffer{}, "", nil))
+
+	assert.Nil(t, c.Ping(time.Second))
+}
+
+func TestSeaweedGetHeaders(t *T) {
+	s, c := testServer(t)
+	defer s.Close()
+
+	ar, err := c.Assign("", "")
+	require.Nil(t, err)
+	f := ar.Filename()
+	err = c.Upload(ar, bytes.NewBufferString("hello"), "text/plain", map[string]string{"ts": "1476719000"})
+	require.Nil(t, err)
+
+	// the headers are sent to seaweed instead of being ignored
+	body, h, err := c.Get(f, map[string]string{"Range": "bytes=1-3"}, nil)
+	require.Nil(t, err)
+	b, err := ioutil.ReadAll(body)
+	body.Close()
+	require.Nil(t, err)
+	assert.Equal(t, "ell", string(b))
+	assert.Equal(t, "bytes 1-3/5", h.Get("Content-Range"))
+
+	_, _, err = c.Get(f, map[string]string{"Range": "bytes=10-"}, nil)
+	assert.NotNil(t, err)
+
+	since := time.Unix(1476719000, 0).UTC().Format(http.TimeFormat)
+	body, _, err = c.Get(f, map[string]string{"If-Modified-Since": since}, nil)
+	require.Nil(t, err)
+	assert.Nil(t, body)
+}
+
+func TestSeaweedAssignN(t *T) {
+	s, c := testServer(t)
+	defer s.Close()
+
+	ars, err := c.AssignN("", "", 3)
+	require.Nil(t, err)
+	require.Len(t, ars, 3)
+	for i, ar := range ars {
+		require.Nil(t, c.Upload(ar, bytes.NewBufferString("file"), "", nil))
+		if i > 0 {
+			assert.Equal(t, ars[0].FID()+"_"+strconv.Itoa(i), ar.FID())
+		}
+	}
+	// the next assign doesn't hand out any of them again
+	ar, err := c.Assign("", "")
+	require.Nil(t, err)
+	for _, a := range ars {
+		assert.NotEqual(t, a.FID(), ar.FID())
+	}
+}
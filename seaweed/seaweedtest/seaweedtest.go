@@ -0,0 +1,320 @@
+// Package seaweedtest provides an in-process fake of a seaweed master and
+// volume server for tests, so dank and services using it can be tested
+// without running seaweed.
+//
+// A single httptest.Server acts as both the master, handling /dir/assign,
+// /dir/lookup and /cluster/status, and the only volume server, storing files
+// in memory. Uploads, gets, heads and deletes of files behave like seaweed,
+// including cookies, ttls, the ts param, If-Modified-Since and Range. Pass
+// Addr to dank as --seaweed-addr, or use it as the SeaweedAddr of the Config
+// given to seaweed.NewClient
+package seaweedtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Volume is the id of the only volume on the server
+const Volume = "1"
+
+type file struct {
+	cookie       string
+	name         string
+	contentType  string
+	data         []byte
+	lastModified time.Time
+	expires      time.Time
+}
+
+// Server is a fake seaweed master and volume server
+type Server struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	lastKey uint64
+	files   map[string]*file
+}
+
+// NewServer starts and returns a new Server, which must be closed with Close
+func NewServer() *Server {
+	s := &Server{
+		files: map[string]*file{},
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// Addr returns the host:port the server listens on
+func (s *Server) Addr() string {
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+// Len returns the number of files stored that haven't expired
+func (s *Server) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int
+	for _, f := range s.files {
+		if !f.expired() {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *file) expired() bool {
+	return !f.expires.IsZero() && time.Now().After(f.expires)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+// ServeHTTP handles requests to both the master and volume APIs
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/dir/assign":
+		s.assign(w, r)
+	case "/dir/lookup":
+		s.lookup(w, r)
+	case "/cluster/status":
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"IsLeader": true,
+			"Leader":   s.Addr(),
+		})
+	default:
+		s.volume(w, r)
+	}
+}
+
+func (s *Server) assign(w http.ResponseWriter, r *http.Request) {
+	count := 1
+	if c := r.URL.Query().Get("count"); c != "" {
+		var err error
+		if count, err = strconv.Atoi(c); err != nil || count < 1 {
+			writeError(w, http.StatusBadRequest, errors.New("invalid count"))
+			return
+		}
+	}
+	if ttl := r.URL.Query().Get("ttl"); ttl != "" {
+		if _, err := parseTTL(ttl); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	s.mu.Lock()
+	// like seaweed, the files after the first are the first fid with _1, _2,
+	// etc appended so the keys of all of them are reserved
+	s.lastKey += uint64(count)
+	key := s.lastKey - uint64(count) + 1
+	s.mu.Unlock()
+	// the cookie only needs to be different for each key
+	cookie := uint32(key * 2654435761)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"fid":       formatFID(key, cookie),
+		"url":       s.Addr(),
+		"publicUrl": s.Addr(),
+		"count":     count,
+	})
+}
+
+// formatFID formats the fid like seaweed does, with the leading zero bytes of
+// the key removed
+func formatFID(key uint64, cookie uint32) string {
+	kc := fmt.Sprintf("%016x%08x", key, cookie)
+	for len(kc) > 8 && strings.HasPrefix(kc, "00") {
+		kc = kc[2:]
+	}
+	return Volume + "," + kc
+}
+
+func (s *Server) lookup(w http.ResponseWriter, r *http.Request) {
+	vid := r.URL.Query().Get("volumeId")
+	if i := strings.Index(vid, ","); i >= 0 {
+		vid = vid[:i]
+	}
+	if vid != Volume {
+		writeError(w, http.StatusNotFound, fmt.Errorf("volume id %s not found", vid))
+		return
+	}
+	loc := map[string]string{"url": s.Addr(), "publicUrl": s.Addr()}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"volumeId":  vid,
+		"locations": []interface{}{loc},
+	})
+}
+
+// parseFID splits a fid, ignoring any extension, into the needle, which is the
+// volume, key and delta, and the cookie
+func parseFID(p string) (string, string, error) {
+	fid := strings.TrimPrefix(p, "/")
+	fid = strings.TrimSuffix(fid, path.Ext(fid))
+	var delta string
+	if i := strings.LastIndex(fid, "_"); i > 0 {
+		fid, delta = fid[:i], fid[i:]
+	}
+	i := strings.Index(fid, ",")
+	if i < 1 || len(fid)-i-1 <= 8 || fid[:i] != Volume {
+		return "", "", errors.New("invalid fid")
+	}
+	c := len(fid) - 8
+	return fid[:c] + delta, fid[c:], nil
+}
+
+func (s *Server) volume(w http.ResponseWriter, r *http.Request) {
+	needle, cookie, err := parseFID(r.URL.Path)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	switch r.Method {
+	case "POST", "PUT":
+		s.upload(w, r, needle, cookie)
+	case "GET", "HEAD":
+		s.get(w, r, needle, cookie)
+	case "DELETE":
+		s.delete(w, r, needle, cookie)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// find returns the file for the needle if it exists, hasn't expired and the
+// cookie matches. It must be called with the lock held
+func (s *Server) find(needle, cookie string) (*file, bool) {
+	f, ok := s.files[needle]
+	if !ok || f.expired() || f.cookie != cookie {
+		return nil, false
+	}
+	return f, true
+}
+
+func (s *Server) upload(w http.ResponseWriter, r *http.Request, needle, cookie string) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	part, err := mr.NextPart()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	data, err := ioutil.ReadAll(part)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	f := &file{
+		cookie:       cookie,
+		name:         part.FileName(),
+		contentType:  part.Header.Get("Content-Type"),
+		data:         data,
+		lastModified: time.Now(),
+	}
+	if ts := r.URL.Query().Get("ts"); ts != "" {
+		sec, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		f.lastModified = time.Unix(sec, 0)
+	}
+	if ttl := r.URL.Query().Get("ttl"); ttl != "" {
+		d, err := parseTTL(ttl)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		f.expires = time.Now().Add(d)
+	}
+
+	s.mu.Lock()
+	// seaweed keeps the cookie a file was first uploaded with
+	if old, ok := s.files[needle]; ok && !old.expired() && old.cookie != cookie {
+		s.mu.Unlock()
+		writeError(w, http.StatusInternalServerError, errors.New("mismatching cookie"))
+		return
+	}
+	s.files[needle] = f
+	s.mu.Unlock()
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"name": f.name,
+		"size": len(f.data),
+	})
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request, needle, cookie string) {
+	s.mu.Lock()
+	f, ok := s.find(needle, cookie)
+	s.mu.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	ct := f.contentType
+	if ct == "" {
+		ct = mime.TypeByExtension(path.Ext(r.URL.Path))
+	}
+	if ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	if f.name != "" {
+		w.Header().Set("Content-Disposition", "inline; filename="+strconv.Quote(f.name))
+	}
+	// handles HEAD, Range, If-Modified-Since and Last-Modified
+	http.ServeContent(w, r, "", f.lastModified, bytes.NewReader(f.data))
+}
+
+func (s *Server) delete(w http.ResponseWriter, r *http.Request, needle, cookie string) {
+	s.mu.Lock()
+	f, ok := s.find(needle, cookie)
+	if ok {
+		delete(s.files, needle)
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]int{"size": len(f.data)})
+}
+
+// parseTTL parses a ttl in seaweed's format, like 3m or 4d
+func parseTTL(ttl string) (time.Duration, error) {
+	units := map[byte]time.Duration{
+		'm': time.Minute,
+		'h': time.Hour,
+		'd': 24 * time.Hour,
+		'w': 7 * 24 * time.Hour,
+		'M': 30 * 24 * time.Hour,
+		'y': 365 * 24 * time.Hour,
+	}
+	if len(ttl) < 2 {
+		return 0, errors.New("invalid ttl")
+	}
+	unit, ok := units[ttl[len(ttl)-1]]
+	n, err := strconv.Atoi(ttl[:len(ttl)-1])
+	if !ok || err != nil || n < 1 {
+		return 0, errors.New("invalid ttl")
+	}
+	return time.Duration(n) * unit, nil
+}
@@ -0,0 +1,94 @@
+package seaweedtest
+
+import (
+	. "testing"
+
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"time"
+)
+
+func assignFID(t *T, s *Server) string {
+	resp, err := http.Get(s.URL + "/dir/assign")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	res := struct {
+		FID string `json:"fid"`
+	}{}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&res))
+	return res.FID
+}
+
+func put(t *T, s *Server, fid, query, body string) int {
+	buf := &bytes.Buffer{}
+	mw := multipart.NewWriter(buf)
+	part, err := mw.CreateFormFile("file", "test.txt")
+	require.Nil(t, err)
+	part.Write([]byte(body))
+	require.Nil(t, mw.Close())
+	req, err := http.NewRequest("PUT", s.URL+"/"+fid+query, buf)
+	require.Nil(t, err)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	resp, err := http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestServer(t *T) {
+	s := NewServer()
+	defer s.Close()
+
+	fid := assignFID(t, s)
+	assert.Equal(t, http.StatusCreated, put(t, s, fid, "?ts=1476719000", "hello world"))
+
+	req, err := http.NewRequest("GET", s.URL+"/"+fid+".txt", nil)
+	require.Nil(t, err)
+	req.Header.Set("Range", "bytes=0-4")
+	resp, err := http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, "hello", string(b))
+	lm := resp.Header.Get("Last-Modified")
+	assert.Equal(t, time.Unix(1476719000, 0).UTC().Format(http.TimeFormat), lm)
+
+	req.Header.Del("Range")
+	req.Header.Set("If-Modified-Since", lm)
+	resp, err = http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	// the cookie can't be changed
+	other := fid[:len(fid)-8] + "00000000"
+	assert.Equal(t, http.StatusInternalServerError, put(t, s, other, "", "bye"))
+	resp, err = http.Get(s.URL + "/" + other)
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestServerTTL(t *T) {
+	s := NewServer()
+	defer s.Close()
+
+	fid := assignFID(t, s)
+	assert.Equal(t, http.StatusCreated, put(t, s, fid, "?ttl=1m", "hello"))
+	assert.Equal(t, 1, s.Len())
+
+	needle, _, err := parseFID(fid)
+	require.Nil(t, err)
+	s.files[needle].expires = time.Now().Add(-time.Second)
+	assert.Equal(t, 0, s.Len())
+	resp, err := http.Get(s.URL + "/" + fid)
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
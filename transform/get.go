@@ -0,0 +1,110 @@
+package transform
+
+import (
+	"bytes"
+	"github.com/levenlabs/dank/backend"
+	"github.com/levenlabs/dank/download"
+	dhttp "github.com/levenlabs/dank/http"
+	"github.com/levenlabs/dank/meta"
+	"github.com/levenlabs/dank/seaweed"
+	"github.com/levenlabs/go-llog"
+	"net/http"
+	"path/filepath"
+)
+
+// Image is a transformed image that could not be stored in the backend
+type Image struct {
+	Data        []byte
+	ContentType string
+}
+
+// Get makes sure the version of filename transformed by o exists in b and
+// returns its filename. If the transformed image isn't one that's stored, or
+// could not be stored, like when the volume is full, then it's returned as well
+// so it can still be sent. s is
+// used to keep the transformed version of a private file private. The original
+// must still exist so a transformed version isn't served after it's deleted,
+// and a new transformed version is stored with the original's ttl, taken from
+// its record in m, which can be nil, or from the backend
+func Get(b backend.Backend, s *download.Signer, m meta.Store, filename string, o *Options) (string, *Image, error) {
+	kv := llog.KV{
+		"filename": filename,
+		"key":      o.Key(),
+	}
+	ext := filepath.Ext(filename)
+	if f := o.format(""); f != "" {
+		ext = "." + f
+	}
+	dname, err := seaweed.DerivedFilename(filename, o.Key(), ext)
+	if err == nil && s.Private(filename) {
+		// otherwise anyone who knows the filename could get the transformed
+		// version without a signature
+		dname, err = s.PrivateFilename(dname)
+	}
+	if err != nil {
+		kv["error"] = err
+		llog.Warn("error deriving transformed filename", kv)
+		return "", nil, dhttp.NewError(http.StatusBadRequest,
+			"invalid filename sent: %s", filename)
+	}
+	kv["derived"] = dname
+
+	ok, err := b.Exists(filename)
+	if err != nil {
+		return "", nil, err
+	} else if !ok {
+		return "", nil, dhttp.NewError(http.StatusNotFound,
+			"filename not found: %s", filename)
+	}
+
+	stored := o.stored()
+	if stored {
+		ok, err = b.Exists(dname)
+		if err != nil {
+			return "", nil, err
+		} else if ok {
+			llog.Debug("transformed image already exists", kv)
+			return dname, nil, nil
+		}
+	}
+
+	body, h, err := b.Get(filename, nil, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	defer body.Close()
+
+	llog.Debug("transforming image", kv)
+	data, ct, err := Transform(body, o)
+	if err == ErrTooLarge {
+		return "", nil, dhttp.NewError(http.StatusBadRequest,
+			"image is larger than %d pixels", MaxPixels)
+	} else if err != nil {
+		kv["error"] = err
+		llog.Info("error transforming image", kv)
+		return "", nil, dhttp.NewError(http.StatusBadRequest,
+			"file could not be transformed as an image")
+	}
+
+	if !stored {
+		llog.Debug("not storing transformed image", kv)
+		return dname, &Image{Data: data, ContentType: ct}, nil
+	}
+
+	ttl := backend.TTL(h)
+	if m != nil {
+		if r, err := m.Get(filename); err == nil && r.Expires != nil {
+			ttl = backend.TTLUntil(*r.Expires)
+		}
+	}
+	var urlParams map[string]string
+	if ttl != "" {
+		urlParams = map[string]string{"ttl": ttl}
+	}
+	if err = b.Put(dname, bytes.NewReader(data), ct, urlParams); err != nil {
+		kv["error"] = err
+		llog.Warn("error storing transformed image", kv)
+		return dname, &Image{Data: data, ContentType: ct}, nil
+	}
+	return dname, nil, nil
+}
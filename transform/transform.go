@@ -0,0 +1,210 @@
+// Package transform resizes and re-encodes images in pure Go. Transformed
+// images are stored in seaweed alongside the original so they only have to be
+// generated once
+package transform
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"golang.org/x/image/draw"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+)
+
+// The ways an image can be fit into the requested width and height when both
+// are sent. Contain scales the image so it fits inside of the box and cover
+// scales it so it fills the box and crops off the rest
+const (
+	FitContain = "contain"
+	FitCover   = "cover"
+)
+
+// MaxDimension is the largest width or height an image can be transformed to
+const MaxDimension = 4096
+
+// MaxPixels is the largest image, in pixels, that will be decoded
+const MaxPixels = 50 * 1000 * 1000
+
+// ErrTooLarge is returned when the original image is larger than MaxPixels
+var ErrTooLarge = errors.New("image is too large to transform")
+
+// Options describes how to transform an image. Any zero value means to leave
+// that part of the image as it is
+type Options struct {
+	// Width and Height are the size of the resulting image. If only one is
+	// sent then the other is calculated from the aspect ratio of the image
+	Width, Height int
+
+	// Fit is FitContain or FitCover and defaults to FitContain
+	Fit string
+
+	// Format is "png", "jpeg" or "gif". By default the original format is
+	// kept if it's one of those and otherwise "png" is used
+	Format string
+
+	// Quality is the quality, from 1 to 100, of jpeg images
+	Quality int
+}
+
+// Validate returns an error if any of the options are out of range
+func (o *Options) Validate() error {
+	if o.Width < 0 || o.Width > MaxDimension ||
+		o.Height < 0 || o.Height > MaxDimension {
+		return fmt.Errorf("width and height must be at most %d", MaxDimension)
+	}
+	if o.Quality < 0 || o.Quality > 100 {
+		return errors.New("quality must be between 1 and 100")
+	}
+	switch o.Fit {
+	case "", FitContain, FitCover:
+	default:
+		return fmt.Errorf("unknown fit %s", o.Fit)
+	}
+	switch o.Format {
+	case "", "png", "jpeg", "jpg", "gif":
+	default:
+		return fmt.Errorf("unknown format %s", o.Format)
+	}
+	return nil
+}
+
+// storedSizes are the widths and heights that transformed images are stored
+// with. Any size can be requested but only these are stored, so anyone who can
+// download a file can't fill the backend with every possible size of it
+var storedSizes = map[int]bool{
+	16: true, 24: true, 32: true, 48: true, 64: true, 96: true, 128: true,
+	160: true, 192: true, 240: true, 256: true, 320: true, 360: true,
+	384: true, 480: true, 512: true, 540: true, 600: true, 640: true,
+	720: true, 768: true, 800: true, 960: true, 1024: true, 1080: true,
+	1280: true, 1440: true, 1536: true, 1920: true, 2048: true, 2160: true,
+	2560: true, 3072: true, 3840: true, 4096: true,
+}
+
+// stored returns true if the transformed image should be stored in the
+// backend. Only the widths and heights in storedSizes and qualities that are
+// multiples of 10 are stored
+func (o *Options) stored() bool {
+	return (o.Width == 0 || storedSizes[o.Width]) &&
+		(o.Height == 0 || storedSizes[o.Height]) &&
+		o.Quality%10 == 0
+}
+
+// Key returns a string that's unique to the result of these options, which is
+// used to find the transformed image again
+func (o *Options) Key() string {
+	fit := o.Fit
+	if fit == "" {
+		fit = FitContain
+	}
+	return fmt.Sprintf("w=%d&h=%d&fit=%s&format=%s&q=%d",
+		o.Width, o.Height, fit, o.format(""), o.Quality)
+}
+
+// format returns the format to encode the image as given the format it was
+// decoded from. An empty src returns an empty format if none was requested
+func (o *Options) format(src string) string {
+	switch o.Format {
+	case "jpg":
+		return "jpeg"
+	case "":
+	default:
+		return o.Format
+	}
+	switch src {
+	case "", "png", "jpeg", "gif":
+		return src
+	}
+	return "png"
+}
+
+// Transform decodes the image read from r, transforms it according to o and
+// returns the encoded image and its content type
+func Transform(r io.Reader, o *Options) ([]byte, string, error) {
+	// check the size before decoding so huge images aren't held in memory
+	head := &bytes.Buffer{}
+	cfg, _, err := image.DecodeConfig(io.TeeReader(r, head))
+	if err != nil {
+		return nil, "", err
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > MaxPixels {
+		return nil, "", ErrTooLarge
+	}
+
+	img, src, err := image.Decode(io.MultiReader(head, r))
+	if err != nil {
+		return nil, "", err
+	}
+	img = resize(img, o.Width, o.Height, o.Fit)
+
+	buf := &bytes.Buffer{}
+	format := o.format(src)
+	switch format {
+	case "jpeg":
+		q := o.Quality
+		if q == 0 {
+			q = jpeg.DefaultQuality
+		}
+		err = jpeg.Encode(buf, img, &jpeg.Options{Quality: q})
+	case "gif":
+		err = gif.Encode(buf, img, nil)
+	default:
+		err = png.Encode(buf, img)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), "image/" + format, nil
+}
+
+// resize scales src to w by h using fit. If either w or h is 0 then it's
+// calculated from the aspect ratio of src. Neither side of the result is larger
+// than MaxDimension
+func resize(src image.Image, w, h int, fit string) image.Image {
+	b := src.Bounds()
+	sw, sh := float64(b.Dx()), float64(b.Dy())
+	if (w == 0 && h == 0) || sw == 0 || sh == 0 {
+		return src
+	}
+
+	sr := b
+	switch {
+	case w == 0:
+		w = round(sw * float64(h) / sh)
+	case h == 0:
+		h = round(sh * float64(w) / sw)
+	case fit == FitCover:
+		// crop the middle of src to the aspect ratio of w by h
+		scale := math.Max(float64(w)/sw, float64(h)/sh)
+		cw, ch := round(float64(w)/scale), round(float64(h)/scale)
+		x := b.Min.X + (b.Dx()-cw)/2
+		y := b.Min.Y + (b.Dy()-ch)/2
+		sr = image.Rect(x, y, x+cw, y+ch)
+	default:
+		scale := math.Min(float64(w)/sw, float64(h)/sh)
+		w, h = round(sw*scale), round(sh*scale)
+	}
+	// a side calculated from the aspect ratio can be far larger than what was
+	// sent, so shrink both until they fit
+	if w > MaxDimension || h > MaxDimension {
+		scale := math.Min(float64(MaxDimension)/float64(w), float64(MaxDimension)/float64(h))
+		w, h = round(float64(w)*scale), round(float64(h)*scale)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, sr, draw.Src, nil)
+	return dst
+}
+
+// round rounds f to the nearest int but never returns less than 1
+func round(f float64) int {
+	i := int(math.Floor(f + 0.5))
+	if i < 1 {
+		return 1
+	}
+	return i
+}
@@ -0,0 +1,186 @@
+package transform
+
+import (
+	. "testing"
+
+	"bytes"
+	"github.com/levenlabs/dank/backend"
+	"github.com/levenlabs/dank/config"
+	"github.com/levenlabs/dank/download"
+	dhttp "github.com/levenlabs/dank/http"
+	"github.com/levenlabs/dank/meta"
+	"github.com/levenlabs/dank/seaweed"
+	"github.com/levenlabs/dank/seaweed/seaweedtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+)
+
+func testPNG(t *T, w, h int) []byte {
+	buf := &bytes.Buffer{}
+	require.Nil(t, png.Encode(buf, image.NewRGBA(image.Rect(0, 0, w, h))))
+	return buf.Bytes()
+}
+
+func TestResize(t *T) {
+	src := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	size := func(w, h int, fit string) (int, int) {
+		b := resize(src, w, h, fit).Bounds()
+		return b.Dx(), b.Dy()
+	}
+
+	w, h := size(0, 0, "")
+	assert.Equal(t, []int{400, 200}, []int{w, h})
+	w, h = size(100, 0, "")
+	assert.Equal(t, []int{100, 50}, []int{w, h})
+	w, h = size(0, 100, "")
+	assert.Equal(t, []int{200, 100}, []int{w, h})
+	w, h = size(100, 100, FitContain)
+	assert.Equal(t, []int{100, 50}, []int{w, h})
+	w, h = size(100, 100, "")
+	assert.Equal(t, []int{100, 50}, []int{w, h})
+	w, h = size(100, 100, FitCover)
+	assert.Equal(t, []int{100, 100}, []int{w, h})
+	w, h = size(800, 800, FitCover)
+	assert.Equal(t, []int{800, 800}, []int{w, h})
+
+	// the side calculated from the aspect ratio is kept under MaxDimension
+	wide := image.NewRGBA(image.Rect(0, 0, 20000, 1))
+	b := resize(wide, 0, MaxDimension, "").Bounds()
+	assert.Equal(t, []int{MaxDimension, 1}, []int{b.Dx(), b.Dy()})
+	tall := image.NewRGBA(image.Rect(0, 0, 1, 20000))
+	b = resize(tall, MaxDimension, 0, "").Bounds()
+	assert.Equal(t, []int{1, MaxDimension}, []int{b.Dx(), b.Dy()})
+}
+
+func TestTransform(t *T) {
+	data, ct, err := Transform(bytes.NewReader(testPNG(t, 40, 20)), &Options{
+		Width: 10,
+	})
+	require.Nil(t, err)
+	assert.Equal(t, "image/png", ct)
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	require.Nil(t, err)
+	assert.Equal(t, "png", format)
+	assert.Equal(t, 10, cfg.Width)
+	assert.Equal(t, 5, cfg.Height)
+
+	data, ct, err = Transform(bytes.NewReader(testPNG(t, 40, 20)), &Options{
+		Format:  "jpg",
+		Quality: 50,
+	})
+	require.Nil(t, err)
+	assert.Equal(t, "image/jpeg", ct)
+	cfg, format, err = image.DecodeConfig(bytes.NewReader(data))
+	require.Nil(t, err)
+	assert.Equal(t, "jpeg", format)
+	assert.Equal(t, 40, cfg.Width)
+
+	_, _, err = Transform(bytes.NewReader([]byte("not an image")), &Options{})
+	assert.NotNil(t, err)
+}
+
+func TestOptions(t *T) {
+	assert.Nil(t, (&Options{Width: 100, Fit: FitCover, Format: "gif"}).Validate())
+	assert.NotNil(t, (&Options{Width: MaxDimension + 1}).Validate())
+	assert.NotNil(t, (&Options{Quality: 101}).Validate())
+	assert.NotNil(t, (&Options{Fit: "stretch"}).Validate())
+	assert.NotNil(t, (&Options{Format: "bmp"}).Validate())
+
+	assert.Equal(t, (&Options{Width: 100}).Key(),
+		(&Options{Width: 100, Fit: FitContain}).Key())
+	assert.Equal(t, (&Options{Format: "jpg"}).Key(),
+		(&Options{Format: "jpeg"}).Key())
+	assert.NotEqual(t, (&Options{Width: 100}).Key(),
+		(&Options{Height: 100}).Key())
+}
+
+func TestGet(t *T) {
+	dir, err := ioutil.TempDir("", "dank-transform-test-")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	b, err := backend.NewLocal(dir)
+	require.Nil(t, err)
+	s := download.NewSigner(&config.Config{})
+
+	ars, err := b.Assign("", "1h", 1)
+	require.Nil(t, err)
+	f := ars[0].Filename() + ".png"
+	err = b.Upload(ars[0], bytes.NewReader(testPNG(t, 40, 20)), "image/png", map[string]string{"ttl": "1h"})
+	require.Nil(t, err)
+
+	// sizes that aren't stored are still transformed
+	dname, img, err := Get(b, s, nil, f, &Options{Width: 10})
+	require.Nil(t, err)
+	require.NotNil(t, img)
+	ok, err := b.Exists(dname)
+	require.Nil(t, err)
+	assert.False(t, ok)
+
+	o := &Options{Width: 16}
+	dname, img, err = Get(b, s, nil, f, o)
+	require.Nil(t, err)
+	assert.Nil(t, img)
+	body, h, err := b.Get(dname, nil, nil)
+	require.Nil(t, err)
+	body.Close()
+	// the transformed version expires with the original
+	expires, err := http.ParseTime(h.Get("Expires"))
+	require.Nil(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), expires, 2*time.Minute)
+
+	// it still exists but isn't served once the original is deleted
+	require.Nil(t, b.Delete(f))
+	_, _, err = Get(b, s, nil, f, o)
+	require.NotNil(t, err)
+	he, ok := err.(dhttp.HTTPError)
+	require.True(t, ok)
+	assert.Equal(t, http.StatusNotFound, he.Code())
+}
+
+// putParams records the urlParams of every Put
+type putParams struct {
+	backend.Backend
+	params []map[string]string
+}
+
+func (p *putParams) Put(filename string, body io.Reader, ct string, urlParams map[string]string) error {
+	p.params = append(p.params, urlParams)
+	return p.Backend.Put(filename, body, ct, urlParams)
+}
+
+func TestGetTTL(t *T) {
+	sw := seaweedtest.NewServer()
+	defer sw.Close()
+	cfg := config.New()
+	cfg.SeaweedAddr = sw.Addr()
+	b := &putParams{Backend: backend.Seaweed(seaweed.NewClient(cfg))}
+	s := download.NewSigner(&config.Config{})
+
+	ars, err := b.Assign("", "1h", 1)
+	require.Nil(t, err)
+	f := ars[0].Filename() + ".png"
+	err = b.Upload(ars[0], bytes.NewReader(testPNG(t, 40, 20)), "image/png", map[string]string{"ttl": "1h"})
+	require.Nil(t, err)
+
+	// seaweed doesn't say when the file expires so without the record there's
+	// no ttl to copy
+	_, _, err = Get(b, s, nil, f, &Options{Width: 16})
+	require.Nil(t, err)
+	require.Len(t, b.params, 1)
+	assert.Nil(t, b.params[0])
+
+	m := meta.NewMemoryStore()
+	expires := time.Now().Add(time.Hour)
+	require.Nil(t, m.Put(&meta.Record{Filename: f, Expires: &expires}))
+	_, _, err = Get(b, s, m, f, &Options{Width: 32})
+	require.Nil(t, err)
+	require.Len(t, b.params, 2)
+	assert.Equal(t, map[string]string{"ttl": "60m"}, b.params[1])
+}
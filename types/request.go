@@ -4,20 +4,24 @@ import (
 	"github.com/levenlabs/go-llog"
 	"gopkg.in/validator.v2"
 	"net/url"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
 var fileTypes = []string{
 	"",
 	"image",
+	"audio",
+	"video",
 }
 
 // AssignRequest encompasses the fields optionally used to validate an upload
-// before passing it onto seaweed. Current this only contains type and size
-// but could later contain min image resolution, song duration, etc
+// before passing it onto seaweed. Current this contains type, size, image
+// dimensions and audio/video duration
 type AssignRequest struct {
-	// Currently only a FileType of "image" is supported
+	// FileType can be "image", "audio" or "video"
 	FileType string `json:"type" mapstructure:"type" validate:"validType"`
 
 	// The maximum number of bytes that the uploaded file can be
@@ -25,6 +29,18 @@ type AssignRequest struct {
 	// the int64 value
 	MaxSizeStr string `json:"max_size" mapstructure:"max_size" validate:"regexp=^[0-9]*$"`
 
+	// The minimum number of bytes that the uploaded file can be
+	// Use MinSize() to get the int64 value
+	MinSizeStr string `json:"min_size" mapstructure:"min_size" validate:"regexp=^[0-9]*$"`
+
+	// The exact number of bytes that the uploaded file must be, if known
+	// ahead of time. Use ExactSize() to get the int64 value
+	ExactSizeStr string `json:"exact_size" mapstructure:"exact_size" validate:"regexp=^[0-9]*$"`
+
+	// Count is the number of files to assign at once. Every file gets its own
+	// signature with the same requirements. Use Count() to get the int value
+	CountStr string `json:"count" mapstructure:"count" validate:"regexp=^[0-9]*$"`
+
 	// Replication is not used in dank and is just forwarded onto seaweedfs
 	Replication string `json:"replication" mapstructure:"replication"`
 
@@ -36,10 +52,62 @@ type AssignRequest struct {
 	// This is a string value so mapstructure can handle it, use Expires() to
 	// get the unix timestamp when the expires is
 	SigExpiresStr string `json:"sigExpires" mapstructure:"sig_expires" validate:"regexp=^[0-9]*$"`
+
+	// The minimum and maximum width and height, in pixels, of an uploaded
+	// image. Sending any of these requires the uploaded file to be an image.
+	// These are string values so mapstructure can handle them, use MinWidth(),
+	// MaxWidth(), MinHeight() and MaxHeight() to get the int values
+	MinWidthStr  string `json:"min_width" mapstructure:"min_width" validate:"regexp=^[0-9]*$"`
+	MaxWidthStr  string `json:"max_width" mapstructure:"max_width" validate:"regexp=^[0-9]*$"`
+	MinHeightStr string `json:"min_height" mapstructure:"min_height" validate:"regexp=^[0-9]*$"`
+	MaxHeightStr string `json:"max_height" mapstructure:"max_height" validate:"regexp=^[0-9]*$"`
+
+	// AspectRatio is the required width / height of an uploaded image, either
+	// as "width:height" or a decimal. Use AspectRatio() to get the float value
+	AspectRatioStr string `json:"aspect_ratio" mapstructure:"aspect_ratio" validate:"regexp=^([0-9]+:[0-9]+|[0-9]*\\.?[0-9]*)$"`
+
+	// AspectRatioToleranceStr is how far off, as a fraction of the
+	// AspectRatio, the uploaded image's aspect ratio can be. Defaults to 0.01
+	// Use AspectRatioTolerance() to get the float value
+	AspectRatioToleranceStr string `json:"aspect_ratio_tolerance" mapstructure:"aspect_ratio_tolerance" validate:"regexp=^[0-9]*\\.?[0-9]*$"`
+
+	// The minimum and maximum duration, in seconds, of uploaded audio or
+	// video. Sending either of these requires the uploaded file to be audio or
+	// video. Use MinDuration() and MaxDuration() to get the time.Duration
+	// values
+	MinDurationStr string `json:"min_duration" mapstructure:"min_duration" validate:"regexp=^[0-9]*\\.?[0-9]*$"`
+	MaxDurationStr string `json:"max_duration" mapstructure:"max_duration" validate:"regexp=^[0-9]*\\.?[0-9]*$"`
+
+	// AllowedTypes is a comma separated list of content types, like
+	// "image/png,application/pdf", that the uploaded file is allowed to be.
+	// Wildcards like "image/*" are allowed. The type is determined from the
+	// contents of the file and not what the uploader says it is. Use
+	// AllowedTypesList() to get the list
+	AllowedTypes string `json:"allowed_types" mapstructure:"allowed_types" validate:"validTypeList"`
+
+	// SHA256 and MD5 are the hex encoded hashes of the file that is allowed to
+	// be uploaded. If either is sent then the signature can only be used to
+	// upload a file with that exact content
+	SHA256 string `json:"sha256" mapstructure:"sha256" validate:"regexp=^([0-9a-fA-F]{64})?$"`
+	MD5    string `json:"md5" mapstructure:"md5" validate:"regexp=^([0-9a-fA-F]{32})?$"`
+
+	// Private marks the uploaded file as private so it can only be downloaded
+	// with a signed url from /sign-get. Use IsPrivate() to get the bool value
+	Private string `json:"private" mapstructure:"private" validate:"regexp=^(|0|1|true|false)$"`
+
+	// Method restricts the signature to only be used to upload with the given
+	// HTTP method. By default any method is allowed
+	Method string `json:"method" mapstructure:"method" validate:"regexp=^(?i)(|POST|PUT)$"`
+
+	// Owner is an optional string, like a user ID, that the signature is bound
+	// to. The same owner must be sent along with the signature whenever it's
+	// used. It is not stored in the signature
+	Owner string `json:"owner" mapstructure:"owner" validate:"max=1024"`
 }
 
 func init() {
 	validator.SetValidationFunc("validType", validateType)
+	validator.SetValidationFunc("validTypeList", validateTypeList)
 }
 
 func stringTypeToIndex(t string) int {
@@ -65,6 +133,27 @@ func validateType(v interface{}, _ string) error {
 	return nil
 }
 
+var contentTypeRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9!#$&^_.+-]*/(\*|[a-zA-Z0-9][a-zA-Z0-9!#$&^_.+-]*)$`)
+
+func validateTypeList(v interface{}, _ string) error {
+	str, ok := v.(string)
+	if !ok {
+		return validator.ErrUnsupported
+	}
+	if str == "" {
+		return nil
+	}
+	for _, t := range strings.Split(str, ",") {
+		if !contentTypeRegex.MatchString(strings.TrimSpace(t)) {
+			llog.Warn("allowed type was invalid", llog.KV{
+				"string": t,
+			})
+			return validator.ErrInvalid
+		}
+	}
+	return nil
+}
+
 // expires returns at what unix time a signature generated with this request
 // expires or 0 if it never expires
 func (r *AssignRequest) Expires() int64 {
@@ -86,6 +175,122 @@ func (r *AssignRequest) MaxSize() int64 {
 	return i
 }
 
+// parseInt returns the int value of one of the string fields or 0 if it's
+// empty or invalid
+func parseInt(s string) int64 {
+	if s == "" {
+		return 0
+	}
+	i, _ := strconv.ParseInt(s, 10, 64)
+	return i
+}
+
+func (r *AssignRequest) MinWidth() int {
+	return int(parseInt(r.MinWidthStr))
+}
+
+func (r *AssignRequest) MaxWidth() int {
+	return int(parseInt(r.MaxWidthStr))
+}
+
+func (r *AssignRequest) MinHeight() int {
+	return int(parseInt(r.MinHeightStr))
+}
+
+func (r *AssignRequest) MaxHeight() int {
+	return int(parseInt(r.MaxHeightStr))
+}
+
+// AspectRatio returns the required width / height of an image or 0 if there
+// is no required aspect ratio
+func (r *AssignRequest) AspectRatio() float64 {
+	if parts := strings.SplitN(r.AspectRatioStr, ":", 2); len(parts) == 2 {
+		w, _ := strconv.ParseFloat(parts[0], 64)
+		h, _ := strconv.ParseFloat(parts[1], 64)
+		if w == 0 || h == 0 {
+			return 0
+		}
+		return w / h
+	}
+	f, _ := strconv.ParseFloat(r.AspectRatioStr, 64)
+	return f
+}
+
+// AspectRatioTolerance returns the allowed fraction the aspect ratio can be
+// off by
+func (r *AssignRequest) AspectRatioTolerance() float64 {
+	if r.AspectRatioToleranceStr == "" {
+		return 0.01
+	}
+	f, _ := strconv.ParseFloat(r.AspectRatioToleranceStr, 64)
+	return f
+}
+
+// HasDimensions returns true if any of the image dimension requirements were
+// sent
+func (r *AssignRequest) HasDimensions() bool {
+	return r.MinWidth() > 0 || r.MaxWidth() > 0 ||
+		r.MinHeight() > 0 || r.MaxHeight() > 0 ||
+		r.AspectRatio() > 0
+}
+
+// parseSeconds returns the time.Duration of one of the string fields holding
+// seconds or 0 if it's empty or invalid
+func parseSeconds(s string) time.Duration {
+	if s == "" {
+		return 0
+	}
+	f, _ := strconv.ParseFloat(s, 64)
+	return time.Duration(f * float64(time.Second))
+}
+
+func (r *AssignRequest) MinDuration() time.Duration {
+	return parseSeconds(r.MinDurationStr)
+}
+
+func (r *AssignRequest) MaxDuration() time.Duration {
+	return parseSeconds(r.MaxDurationStr)
+}
+
+// AllowedTypesList returns the list of allowed content types or nil if every
+// type is allowed
+func (r *AssignRequest) AllowedTypesList() []string {
+	if r.AllowedTypes == "" {
+		return nil
+	}
+	l := strings.Split(r.AllowedTypes, ",")
+	for i := range l {
+		l[i] = strings.TrimSpace(l[i])
+	}
+	return l
+}
+
+// HasDuration returns true if either of the duration requirements were sent
+func (r *AssignRequest) HasDuration() bool {
+	return r.MinDuration() > 0 || r.MaxDuration() > 0
+}
+
+func (r *AssignRequest) MinSize() int64 {
+	return parseInt(r.MinSizeStr)
+}
+
+func (r *AssignRequest) ExactSize() int64 {
+	return parseInt(r.ExactSizeStr)
+}
+
+// IsPrivate returns true if the file should be marked private
+func (r *AssignRequest) IsPrivate() bool {
+	return r.Private == "1" || r.Private == "true"
+}
+
+// Count returns the number of files to assign, which is at least 1
+func (r *AssignRequest) Count() int {
+	if i := int(parseInt(r.CountStr)); i > 1 {
+		return i
+	}
+	return 1
+}
+
 func (r *AssignRequest) FileTypeID() int {
 	return stringTypeToIndex(r.FileType)
 }
@@ -98,6 +303,15 @@ func (r *AssignRequest) URLValues() url.Values {
 	if r.MaxSizeStr != "" {
 		v.Set("max_size", r.MaxSizeStr)
 	}
+	if r.MinSizeStr != "" {
+		v.Set("min_size", r.MinSizeStr)
+	}
+	if r.ExactSizeStr != "" {
+		v.Set("exact_size", r.ExactSizeStr)
+	}
+	if r.CountStr != "" {
+		v.Set("count", r.CountStr)
+	}
 	if r.Replication != "" {
 		v.Set("replication", r.Replication)
 	}
@@ -107,6 +321,48 @@ func (r *AssignRequest) URLValues() url.Values {
 	if r.SigExpiresStr != "" {
 		v.Set("sig_expires", r.SigExpiresStr)
 	}
+	if r.MinWidthStr != "" {
+		v.Set("min_width", r.MinWidthStr)
+	}
+	if r.MaxWidthStr != "" {
+		v.Set("max_width", r.MaxWidthStr)
+	}
+	if r.MinHeightStr != "" {
+		v.Set("min_height", r.MinHeightStr)
+	}
+	if r.MaxHeightStr != "" {
+		v.Set("max_height", r.MaxHeightStr)
+	}
+	if r.AspectRatioStr != "" {
+		v.Set("aspect_ratio", r.AspectRatioStr)
+	}
+	if r.AspectRatioToleranceStr != "" {
+		v.Set("aspect_ratio_tolerance", r.AspectRatioToleranceStr)
+	}
+	if r.MinDurationStr != "" {
+		v.Set("min_duration", r.MinDurationStr)
+	}
+	if r.MaxDurationStr != "" {
+		v.Set("max_duration", r.MaxDurationStr)
+	}
+	if r.AllowedTypes != "" {
+		v.Set("allowed_types", r.AllowedTypes)
+	}
+	if r.SHA256 != "" {
+		v.Set("sha256", r.SHA256)
+	}
+	if r.MD5 != "" {
+		v.Set("md5", r.MD5)
+	}
+	if r.Private != "" {
+		v.Set("private", r.Private)
+	}
+	if r.Method != "" {
+		v.Set("method", r.Method)
+	}
+	if r.Owner != "" {
+		v.Set("owner", r.Owner)
+	}
 	return v
 }
 
@@ -6,4 +6,8 @@ package types
 type Assignment struct {
 	Signature string `json:"sig" mapstructure:"sig"  validate:"nonzero"`
 	Filename  string `json:"filename"  mapstructure:"filename" validate:"nonzero"`
+
+	// Owner must match the owner sent to assign, if any. It's never returned
+	// from assign
+	Owner string `json:"owner,omitempty" mapstructure:"owner"`
 }
@@ -0,0 +1,278 @@
+package upload
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	dhttp "github.com/levenlabs/dank/http"
+	"github.com/levenlabs/dank/metrics"
+	"github.com/levenlabs/dank/types"
+	"github.com/levenlabs/go-llog"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Resumable uploads are sent in chunks which are appended to a file in the
+// ChunkDir of the Config named after the signature. Once the last chunk is sent
+// the file is uploaded with Upload like any other body and then removed
+
+// how often files for abandoned uploads are removed from the ChunkDir
+var chunkPruneInterval = time.Minute
+
+// chunkLock is a lock on a single chunk file. refs is the number of callers
+// holding or waiting for it so it can be removed once nobody is
+type chunkLock struct {
+	sync.Mutex
+	refs int
+}
+
+// chunkLocks holds the lock of every chunk file in use
+type chunkLocks struct {
+	sync.Mutex
+	m         map[string]*chunkLock
+	lastPrune time.Time
+}
+
+func newChunkLocks() *chunkLocks {
+	return &chunkLocks{
+		m: map[string]*chunkLock{},
+	}
+}
+
+// lockChunks locks the chunk file at path and returns the function to unlock
+// it. It also removes any abandoned chunk files if it hasn't in a while
+func (u *Uploader) lockChunks(path string) func() {
+	cl := u.chunkLocks
+	cl.Lock()
+	if now := time.Now(); now.Sub(cl.lastPrune) >= chunkPruneInterval {
+		cl.lastPrune = now
+		go u.pruneChunks(now)
+	}
+	l, ok := cl.m[path]
+	if !ok {
+		l = &chunkLock{}
+		cl.m[path] = l
+	}
+	l.refs++
+	cl.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		cl.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(cl.m, path)
+		}
+		cl.Unlock()
+	}
+}
+
+// pruneChunks removes chunk files that haven't been written to within the
+// ChunkTTL
+func (u *Uploader) pruneChunks(now time.Time) {
+	fis, err := ioutil.ReadDir(u.cfg.ChunkDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			llog.Warn("error reading chunk dir", llog.KV{
+				"dir":   u.cfg.ChunkDir,
+				"error": err,
+			})
+		}
+		return
+	}
+	for _, fi := range fis {
+		if fi.IsDir() || now.Sub(fi.ModTime()) < u.cfg.ChunkTTL {
+			continue
+		}
+		path := filepath.Join(u.cfg.ChunkDir, fi.Name())
+		unlock := u.lockChunks(path)
+		// it could've been written to while waiting for the lock
+		if fi, err = os.Stat(path); err == nil && now.Sub(fi.ModTime()) >= u.cfg.ChunkTTL {
+			os.Remove(path)
+		}
+		unlock()
+	}
+}
+
+// chunkPath returns the path of the chunk file for the signature. It's named
+// after the decoded nonce so every way of writing the nonce shares one file
+func (u *Uploader) chunkPath(sig *signature) string {
+	h := sha256.Sum256([]byte(sig.nonce))
+	return filepath.Join(u.cfg.ChunkDir, hex.EncodeToString(h[:]))
+}
+
+// decodeChunkSignature decodes the signature for a chunk request and makes sure
+// it hasn't already been used
+func (u *Uploader) decodeChunkSignature(a *types.Assignment, method string) (*signature, error) {
+	sig, _, err := u.decodeSignature(a, method)
+	if err != nil {
+		llog.Info("error running decode in chunk", llog.KV{
+			"error":    err,
+			"filename": a.Filename,
+			"sig":      a.Signature,
+		})
+		return nil, dhttp.NewError(http.StatusBadRequest, "invalid signature or filename")
+	}
+	used, err := u.ReplayStore.Used(sig.nonce)
+	if err != nil {
+		return nil, err
+	} else if used {
+		return nil, dhttp.NewError(http.StatusConflict, "signature already used")
+	} else if !sig.canonical {
+		return nil, dhttp.NewError(http.StatusBadRequest, "invalid signature or filename")
+	}
+	return sig, nil
+}
+
+// chunkSize returns the size of the chunk file at path or 0 if it doesn't
+// exist
+func chunkSize(path string) (int64, error) {
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// Offset returns how many bytes of a resumable upload have been received for
+// the Assignment
+func (u *Uploader) Offset(a *types.Assignment) (int64, error) {
+	sig, err := u.decodeChunkSignature(a, "")
+	if err != nil {
+		return 0, err
+	}
+	path := u.chunkPath(sig)
+	unlock := u.lockChunks(path)
+	defer unlock()
+	return chunkSize(path)
+}
+
+// Chunk writes body at offset in the resumable upload for the Assignment and
+// returns the new offset. offset can't be past the bytes already received and
+// anything already received after offset is replaced. The total size is
+// checked against the MaxSize and ExactSize of the original AssignRequest as
+// chunks are received. If reading body fails the bytes read are still kept so
+// the upload can resume from there
+func (u *Uploader) Chunk(a *types.Assignment, method string, offset int64, body io.Reader) (int64, error) {
+	sig, err := u.decodeChunkSignature(a, method)
+	if err != nil {
+		return 0, err
+	}
+	r := sig.Req.decompress()
+	limit := r.MaxSize()
+	if e := r.ExactSize(); e > 0 && (limit == 0 || e < limit) {
+		limit = e
+	}
+	kv := llog.KV{
+		"filename": a.Filename,
+		"offset":   offset,
+		"limit":    limit,
+	}
+
+	path := u.chunkPath(sig)
+	unlock := u.lockChunks(path)
+	defer unlock()
+	size, err := chunkSize(path)
+	if err != nil {
+		return 0, err
+	}
+	if offset < 0 || offset > size {
+		return size, dhttp.NewError(http.StatusConflict, "offset must be at most %d", size)
+	}
+	if limit > 0 && offset > limit {
+		err = dhttp.NewError(http.StatusRequestEntityTooLarge, "request is larger than %d bytes", limit).WithReason("max_size")
+		metrics.Rejected(err)
+		return size, err
+	}
+
+	if err = os.MkdirAll(u.cfg.ChunkDir, 0700); err != nil {
+		kv["error"] = err
+		llog.Error("error creating chunk dir", kv)
+		return size, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		kv["error"] = err
+		llog.Error("error opening chunk file", kv)
+		return size, err
+	}
+	defer f.Close()
+	if err = f.Truncate(offset); err == nil {
+		_, err = f.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		kv["error"] = err
+		llog.Error("error truncating chunk file", kv)
+		return size, err
+	}
+
+	if limit > 0 {
+		// read one extra byte to know if the limit was exceeded
+		body = io.LimitReader(body, limit-offset+1)
+	}
+	n, cerr := io.Copy(f, body)
+	size = offset + n
+	if limit > 0 && size > limit {
+		size = offset
+		cerr = dhttp.NewError(http.StatusRequestEntityTooLarge, "request is larger than %d bytes", limit).WithReason("max_size")
+		metrics.Rejected(cerr)
+	}
+	// even if the copy failed keep what was written so it can be resumed
+	if err = f.Truncate(size); err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		kv["error"] = err
+		llog.Error("error writing chunk file", kv)
+		return offset, err
+	}
+	if cerr != nil {
+		kv["error"] = cerr
+		llog.Info("error reading chunk", kv)
+		return size, cerr
+	}
+	return size, nil
+}
+
+// Commit uploads everything received for the resumable upload for the
+// Assignment with Upload. If the upload succeeds the received chunks are
+// removed. See Upload for the other arguments
+func (u *Uploader) Commit(a *types.Assignment, method, ct, name string, urlParams map[string]string) (*Result, error) {
+	sig, err := u.decodeChunkSignature(a, method)
+	if err != nil {
+		return nil, err
+	}
+	path := u.chunkPath(sig)
+	unlock := u.lockChunks(path)
+	defer unlock()
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, dhttp.NewError(http.StatusBadRequest, "empty body uploaded")
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := u.Upload(a, method, f, fi.Size(), ct, name, urlParams)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Remove(path); err != nil {
+		llog.Warn("error removing chunk file", llog.KV{
+			"file":  path,
+			"error": err,
+		})
+	}
+	return res, nil
+}
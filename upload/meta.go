@@ -0,0 +1,54 @@
+package upload
+
+import (
+	"fmt"
+	dhttp "github.com/levenlabs/dank/http"
+	"github.com/levenlabs/dank/meta"
+	"github.com/levenlabs/go-llog"
+	"net/http"
+)
+
+// newMetaStore returns a meta.Store that keeps its records in file, or only in
+// memory if file is empty
+func newMetaStore(file string) (meta.Store, error) {
+	if file == "" {
+		return meta.NewMemoryStore(), nil
+	}
+	s, err := meta.NewFileStore(file)
+	if err != nil {
+		return nil, fmt.Errorf("error opening meta file %s: %s", file, err)
+	}
+	return s, nil
+}
+
+// Stat returns the information recorded about the file when it was uploaded.
+// If nothing was recorded then a 404 error is returned
+func (u *Uploader) Stat(filename string) (*meta.Record, error) {
+	r, err := u.MetaStore.Get(filename)
+	if err == meta.ErrNotFound {
+		return nil, dhttp.NewError(http.StatusNotFound, "no information for filename: %s", filename)
+	} else if err != nil {
+		llog.Error("error getting file metadata", llog.KV{
+			"filename": filename,
+			"error":    err,
+		})
+		return nil, err
+	}
+	return r, nil
+}
+
+// Delete deletes the file from the backend and forgets the information recorded
+// about it
+func (u *Uploader) Delete(filename string) error {
+	if err := u.backend.Delete(filename); err != nil {
+		return err
+	}
+	if err := u.MetaStore.Delete(filename); err != nil {
+		// the file is already gone so don't fail the delete
+		llog.Error("error deleting file metadata", llog.KV{
+			"filename": filename,
+			"error":    err,
+		})
+	}
+	return nil
+}
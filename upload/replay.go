@@ -0,0 +1,76 @@
+package upload
+
+import (
+	"fmt"
+	dhttp "github.com/levenlabs/dank/http"
+	"github.com/levenlabs/dank/replay"
+	"github.com/levenlabs/dank/types"
+	"github.com/levenlabs/go-llog"
+	"net/http"
+	"time"
+)
+
+// newReplayStore returns a replay.Store that keeps used signatures in file, or
+// only in memory if file is empty
+func newReplayStore(file string) (replay.Store, error) {
+	if file == "" {
+		return replay.NewMemoryStore(), nil
+	}
+	s, err := replay.NewFileStore(file)
+	if err != nil {
+		return nil, fmt.Errorf("error opening replay file %s: %s", file, err)
+	}
+	return s, nil
+}
+
+// claim marks the signature as used. If it was already used then a 409 error
+// is returned. A signature whose nonce was sent in a different form than it
+// was signed with is never claimed, only told if it was already used
+func (u *Uploader) claim(sig *signature) error {
+	if !sig.canonical {
+		used, err := u.ReplayStore.Used(sig.nonce)
+		if err != nil {
+			return err
+		} else if used {
+			return dhttp.NewError(http.StatusConflict, "signature already used").WithReason("used")
+		}
+		return dhttp.NewError(http.StatusBadRequest, "invalid signature or filename").WithReason("signature")
+	}
+	var expires time.Time
+	if sig.Expires > 0 {
+		expires = time.Unix(sig.Expires, 0)
+	} else if u.cfg.ReplayTTL > 0 {
+		expires = time.Now().Add(u.cfg.ReplayTTL)
+	}
+	err := u.ReplayStore.Claim(sig.nonce, expires)
+	if err == replay.ErrUsed {
+		return dhttp.NewError(http.StatusConflict, "signature already used").WithReason("used")
+	} else if err != nil {
+		llog.Error("error claiming signature", llog.KV{
+			"nonce": sig.nonce,
+			"error": err,
+		})
+		return err
+	}
+	return nil
+}
+
+// release allows the signature to be used again after a failed upload
+func (u *Uploader) release(sig *signature) {
+	if err := u.ReplayStore.Release(sig.nonce); err != nil {
+		llog.Error("error releasing signature", llog.KV{
+			"nonce": sig.nonce,
+			"error": err,
+		})
+	}
+}
+
+// Used takes an assignment and returns whether its signature has already been
+// used to upload a file
+func (u *Uploader) Used(a *types.Assignment) (bool, error) {
+	sig, _, err := u.decodeSignature(a, "")
+	if err != nil {
+		return false, dhttp.NewError(http.StatusBadRequest, "invalid signature or filename")
+	}
+	return u.ReplayStore.Used(sig.nonce)
+}
@@ -1,8 +1,14 @@
 package upload
 
 import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	dhttp "github.com/levenlabs/dank/http"
 	"github.com/levenlabs/dank/types"
+	"net/http"
 	"strconv"
+	"time"
 )
 
 // compressedAssignRequest is just a compressed version of the AssignRequest
@@ -13,22 +19,113 @@ type compressedAssignRequest struct {
 	FileTypeIndex int    `msgpack:"i"`
 	MaxSize       int64  `msgpack:"s"`
 	TTL           string `msgpack:"t"`
+
+	// these are omitted when empty so signatures without them don't grow
+	MinWidth             int    `msgpack:"mw,omitempty"`
+	MaxWidth             int    `msgpack:"xw,omitempty"`
+	MinHeight            int    `msgpack:"mh,omitempty"`
+	MaxHeight            int    `msgpack:"xh,omitempty"`
+	AspectRatio          string `msgpack:"ar,omitempty"`
+	AspectRatioTolerance string `msgpack:"at,omitempty"`
+
+	// durations are in milliseconds
+	MinDuration int64 `msgpack:"md,omitempty"`
+	MaxDuration int64 `msgpack:"xd,omitempty"`
+
+	AllowedTypes string `msgpack:"ct,omitempty"`
+
+	MinSize   int64 `msgpack:"ms,omitempty"`
+	ExactSize int64 `msgpack:"es,omitempty"`
+
+	// hashes are stored as raw bytes instead of hex to keep them smaller
+	SHA256 []byte `msgpack:"hs,omitempty"`
+	MD5    []byte `msgpack:"hm,omitempty"`
 }
 
-// compress turns a AssignRequest into a compressedAssignRequest
-func compressRequest(r *types.AssignRequest) *compressedAssignRequest {
+// compress turns a AssignRequest into a compressedAssignRequest. It returns a
+// 400 error if a hash is invalid since dropping it would let any file be
+// uploaded
+func compressRequest(r *types.AssignRequest) (*compressedAssignRequest, error) {
+	sha, err := hexBytes("sha256", r.SHA256, sha256.Size)
+	if err != nil {
+		return nil, err
+	}
+	md, err := hexBytes("md5", r.MD5, md5.Size)
+	if err != nil {
+		return nil, err
+	}
 	return &compressedAssignRequest{
-		FileTypeIndex: r.FileTypeID(),
-		MaxSize:       r.MaxSize(),
-		TTL:           r.TTL,
+		FileTypeIndex:        r.FileTypeID(),
+		MaxSize:              r.MaxSize(),
+		TTL:                  r.TTL,
+		MinWidth:             r.MinWidth(),
+		MaxWidth:             r.MaxWidth(),
+		MinHeight:            r.MinHeight(),
+		MaxHeight:            r.MaxHeight(),
+		AspectRatio:          r.AspectRatioStr,
+		AspectRatioTolerance: r.AspectRatioToleranceStr,
+		MinDuration:          int64(r.MinDuration() / time.Millisecond),
+		MaxDuration:          int64(r.MaxDuration() / time.Millisecond),
+		AllowedTypes:         r.AllowedTypes,
+		MinSize:              r.MinSize(),
+		ExactSize:            r.ExactSize(),
+		SHA256:               sha,
+		MD5:                  md,
+	}, nil
+}
+
+// intStr returns the string value of i or an empty string if it's 0
+func intStr(i int) string {
+	return int64Str(int64(i))
+}
+
+func int64Str(i int64) string {
+	if i == 0 {
+		return ""
+	}
+	return strconv.FormatInt(i, 10)
+}
+
+// hexBytes decodes the hex encoded hash called name, which must be size bytes,
+// or returns nil if it's empty
+func hexBytes(name, s string, size int) ([]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != size {
+		return nil, dhttp.NewError(http.StatusBadRequest, "invalid %s: %s", name, s)
+	}
+	return b, nil
+}
+
+// msStr returns the string value, in seconds, of ms milliseconds or an empty
+// string if it's 0
+func msStr(ms int64) string {
+	if ms == 0 {
+		return ""
 	}
+	return strconv.FormatFloat(float64(ms)/1000, 'f', -1, 64)
 }
 
 // decompress turns a compressedAssignRequest into a decompress
 func (r compressedAssignRequest) decompress() *types.AssignRequest {
 	return &types.AssignRequest{
-		FileType:   types.FileTypeFromID(r.FileTypeIndex),
-		MaxSizeStr: strconv.FormatInt(r.MaxSize, 10),
-		TTL:        r.TTL,
+		FileType:                types.FileTypeFromID(r.FileTypeIndex),
+		MaxSizeStr:              strconv.FormatInt(r.MaxSize, 10),
+		TTL:                     r.TTL,
+		MinWidthStr:             intStr(r.MinWidth),
+		MaxWidthStr:             intStr(r.MaxWidth),
+		MinHeightStr:            intStr(r.MinHeight),
+		MaxHeightStr:            intStr(r.MaxHeight),
+		AspectRatioStr:          r.AspectRatio,
+		AspectRatioToleranceStr: r.AspectRatioTolerance,
+		MinDurationStr:          msStr(r.MinDuration),
+		MaxDurationStr:          msStr(r.MaxDuration),
+		AllowedTypes:            r.AllowedTypes,
+		MinSizeStr:              int64Str(r.MinSize),
+		ExactSizeStr:            int64Str(r.ExactSize),
+		SHA256:                  hex.EncodeToString(r.SHA256),
+		MD5:                     hex.EncodeToString(r.MD5),
 	}
 }
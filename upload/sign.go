@@ -4,6 +4,7 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -11,8 +12,10 @@ import (
 	"github.com/levenlabs/dank/seaweed"
 	"github.com/levenlabs/dank/types"
 	"github.com/levenlabs/go-llog"
+	"golang.org/x/crypto/hkdf"
 	"gopkg.in/vmihailenco/msgpack.v2"
 	"hash/crc32"
+	"io"
 	"strings"
 	"time"
 )
@@ -29,16 +32,56 @@ type signature struct {
 
 	// Expires represents the unix time that this expires
 	Expires int64 `msgpack:"e"`
+
+	// nonce is the nonce the signature was encrypted with, re-encoded from
+	// its bytes. It's unique per signature so it's used as the key in the
+	// replay store. The decoder ignores newlines, so the nonce sent can't be
+	// used as the key directly or one signature would have many keys
+	nonce string
+
+	// canonical is false if the nonce sent isn't exactly how it encodes
+	canonical bool
+}
+
+// Signer makes and checks the signatures used to upload files with the
+// Keyring of a Config. The first key is used to sign and the rest are only used
+// to verify
+type Signer struct {
+	keyring []config.Key
+}
+
+// NewSigner returns a Signer using the Keyring of cfg, which can't be empty
+func NewSigner(cfg *config.Config) (*Signer, error) {
+	if len(cfg.Keyring) == 0 {
+		return nil, errors.New("a secret is required")
+	}
+	return &Signer{keyring: cfg.Keyring}, nil
 }
 
-func init() {
-	if config.Secret == "" {
-		llog.Fatal("--secret is required")
+// hkdfInfo is used when deriving a cipher key from a secret so the same secret
+// used elsewhere wouldn't result in the same key
+var hkdfInfo = []byte("dank signature v3")
+
+// deriveKey turns a secret of any length into a 256-bit key using HKDF
+func deriveKey(secret string) ([]byte, error) {
+	key := make([]byte, 32)
+	r := hkdf.New(sha256.New, []byte(secret), nil, hkdfInfo)
+	if _, err := io.ReadFull(r, key); err != nil {
+		return nil, err
 	}
+	return key, nil
 }
 
-func gcm() (cipher.AEAD, error) {
-	key := []byte(config.Secret)
+// gcm returns the AEAD for the given key. Version 1 and 2 signatures used the
+// secret directly as the AES key so legacy should be true for those
+func gcm(k config.Key, legacy bool) (cipher.AEAD, error) {
+	key := []byte(k.Secret)
+	if !legacy {
+		var err error
+		if key, err = deriveKey(k.Secret); err != nil {
+			return nil, err
+		}
+	}
 	b, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
@@ -46,14 +89,42 @@ func gcm() (cipher.AEAD, error) {
 	return cipher.NewGCM(b)
 }
 
-// encode returns an encrypted string signature for the given AssignRequest and
-// seaweed.AssignResult. It crc's the filename from the result and uses a gcm
-// cipher to encrypt the signature struct
-func encode(r *types.AssignRequest, ar *seaweed.AssignResult) (string, error) {
+// findKey returns the key in the keyring with the given id
+func (s *Signer) findKey(id string) (config.Key, bool) {
+	for _, k := range s.keyring {
+		if k.ID == id {
+			return k, true
+		}
+	}
+	return config.Key{}, false
+}
+
+// associatedData returns the data authenticated, but not encrypted, along with
+// version 4 signatures. The method is stored in the signature but the filename
+// and owner must be sent by whoever is using the signature
+func associatedData(filename, method, owner string) []byte {
+	return []byte(strings.Join([]string{"dank4", filename, method, owner}, "\x00"))
+}
+
+// Sign returns an encrypted string signature for the given AssignRequest and
+// seaweed.AssignResult. It uses a gcm cipher to encrypt the signature struct
+// and authenticates the filename, method and owner as associated data. It's
+// what Assign uses once the file is assigned, so it can be used to sign a file
+// that was assigned some other way
+//
+// The signature is encrypted with a key derived from the first secret in the
+// keyring and is in the format "4$keyID$method$nonce$ciphertext"
+func (s *Signer) Sign(r *types.AssignRequest, ar *seaweed.AssignResult) (string, error) {
+	k := s.keyring[0]
 	kv := llog.KV{
 		"filename": ar.Filename(),
+		"keyID":    k.ID,
 	}
-	g, err := gcm()
+	req, err := compressRequest(r)
+	if err != nil {
+		return "", err
+	}
+	g, err := gcm(k, false)
 	if err != nil {
 		kv["error"] = err
 		llog.Error("error creating gcm", kv)
@@ -67,7 +138,7 @@ func encode(r *types.AssignRequest, ar *seaweed.AssignResult) (string, error) {
 	}
 
 	sig := &signature{
-		Req:        compressRequest(r),
+		Req:        req,
 		SeaweedURL: ar.Host(),
 		CRC:        crc32.ChecksumIEEE([]byte(ar.Filename())),
 		Expires:    r.Expires(),
@@ -78,24 +149,76 @@ func encode(r *types.AssignRequest, ar *seaweed.AssignResult) (string, error) {
 		llog.Error("error marshaling msgpack", kv)
 		return "", err
 	}
+	method := strings.ToUpper(r.Method)
+	ad := associatedData(ar.Filename(), method, r.Owner)
 	res := strings.Join([]string{
-		"1",
+		"4",
+		k.ID,
+		method,
 		encoder.EncodeToString(nonce),
-		encoder.EncodeToString(g.Seal(nil, nonce, b, nil)),
+		encoder.EncodeToString(g.Seal(nil, nonce, b, ad)),
 	}, "$")
 	return res, nil
 }
 
-// decode takes the encrypted string signature from encode and the filename
+// decode takes the encrypted string signature from Sign and the filename
 // and validates that the filename matches the one originally sent to encode.
 // It returns the original AssignRequest and a new seaweed.AssignResult that can
 // be used to upload the file
-func decode(s string, f string) (*types.AssignRequest, *seaweed.AssignResult, error) {
+func (s *Signer) decode(str string, f string) (*types.AssignRequest, *seaweed.AssignResult, error) {
+	sig, ar, err := s.decodeSignature(&types.Assignment{Signature: str, Filename: f}, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	return sig.Req.decompress(), ar, nil
+}
+
+// decodeSignature is like decode but takes the whole Assignment, including the
+// owner, and returns the whole decrypted signature. If method is not empty and
+// the signature only allows a specific method, then they must match
+func (s *Signer) decodeSignature(a *types.Assignment, method string) (*signature, *seaweed.AssignResult, error) {
+	str, f := a.Signature, a.Filename
 	kv := llog.KV{
-		"string": s,
+		"string": str,
+	}
+	ar, err := seaweed.NewAssignResult("", f)
+	if err != nil {
+		kv["error"] = err
+		kv["filename"] = f
+		llog.Debug("error decoding filename", kv)
+		return nil, nil, fmt.Errorf("unauthorized filename sent")
 	}
-	parts := strings.Split(s, "$")
-	if len(parts) != 3 || parts[0] != "1" {
+
+	// version 1 signatures don't have a key id so every key is tried, version
+	// 2 and 3 signatures are "version$keyID$nonce$ciphertext". Versions 1 and
+	// 2 used the secret directly as the key. Version 4 signatures are
+	// "4$keyID$method$nonce$ciphertext" and have associated data
+	parts := strings.Split(str, "$")
+	var keys []config.Key
+	var ad []byte
+	var sigMethod string
+	legacy := true
+	switch {
+	case len(parts) == 3 && parts[0] == "1":
+		keys = s.keyring
+	case len(parts) == 4 && (parts[0] == "2" || parts[0] == "3"),
+		len(parts) == 5 && parts[0] == "4":
+		k, ok := s.findKey(parts[1])
+		if !ok {
+			kv["keyID"] = parts[1]
+			llog.Debug("unknown key id", kv)
+			return nil, nil, errors.New("invalid signature")
+		}
+		keys = []config.Key{k}
+		legacy = parts[0] == "1" || parts[0] == "2"
+		if parts[0] == "4" {
+			sigMethod = parts[2]
+			ad = associatedData(ar.Filename(), sigMethod, a.Owner)
+			parts = parts[1:]
+		}
+		// drop the version so the nonce and ciphertext line up with version 1
+		parts = parts[1:]
+	default:
 		kv["len"] = len(parts)
 		llog.Debug("number of parts was invalid", kv)
 		return nil, nil, errors.New("invalid signature")
@@ -103,27 +226,36 @@ func decode(s string, f string) (*types.AssignRequest, *seaweed.AssignResult, er
 	nonce, err := encoder.DecodeString(parts[1])
 	if err != nil {
 		kv["error"] = err
-		llog.Debug("error base64 decoding signature part 1", kv)
+		llog.Debug("error base64 decoding signature nonce", kv)
 		return nil, nil, err
 	}
 	c, err := encoder.DecodeString(parts[2])
 	if err != nil {
 		kv["error"] = err
-		llog.Debug("error base64 decoding signature part 2", kv)
+		llog.Debug("error base64 decoding signature ciphertext", kv)
 		return nil, nil, err
 	}
-	g, err := gcm()
-	if err != nil {
-		kv["error"] = err
-		llog.Error("error creating gcm", kv)
-		return nil, nil, err
+	var v []byte
+	for _, k := range keys {
+		var g cipher.AEAD
+		// legacy signatures can't be opened by secrets that aren't a valid
+		// AES key length so just skip those
+		if g, err = gcm(k, legacy); err != nil {
+			kv["error"] = err
+			kv["keyID"] = k.ID
+			llog.Debug("error creating gcm", kv)
+			continue
+		}
+		if v, err = g.Open(nil, nonce, c, ad); err == nil {
+			break
+		}
 	}
-	v, err := g.Open(nil, nonce, c, nil)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	sig := &signature{}
+	sig := &signature{nonce: encoder.EncodeToString(nonce)}
+	sig.canonical = sig.nonce == parts[1]
 	err = msgpack.Unmarshal(v, sig)
 	if err != nil {
 		kv["error"] = err
@@ -138,7 +270,14 @@ func decode(s string, f string) (*types.AssignRequest, *seaweed.AssignResult, er
 		return nil, nil, errors.New("signature expired")
 	}
 
-	ar, err := seaweed.NewAssignResult(sig.SeaweedURL, f)
+	if method != "" && sigMethod != "" && !strings.EqualFold(method, sigMethod) {
+		kv["method"] = method
+		kv["sigMethod"] = sigMethod
+		llog.Debug("method not allowed by signature", kv)
+		return nil, nil, errors.New("method not allowed by signature")
+	}
+
+	ar, err = seaweed.NewAssignResult(sig.SeaweedURL, f)
 	if err != nil || crc32.ChecksumIEEE([]byte(ar.Filename())) != sig.CRC {
 		kv["error"] = err
 		kv["crc"] = sig.CRC
@@ -147,5 +286,5 @@ func decode(s string, f string) (*types.AssignRequest, *seaweed.AssignResult, er
 		return nil, nil, fmt.Errorf("unauthorized filename sent")
 	}
 
-	return sig.Req.decompress(), ar, nil
+	return sig, ar, nil
 }
@@ -4,28 +4,51 @@ import (
 	. "testing"
 
 	"encoding/base64"
+	"github.com/levenlabs/dank/config"
 	"github.com/levenlabs/dank/seaweed"
+	"github.com/levenlabs/dank/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/vmihailenco/msgpack.v2"
+	"hash/crc32"
+	"strings"
 	"time"
 )
 
+var testKeyring = []config.Key{{ID: "0", Secret: "test"}}
+
+func testSigner(t *T, keyring []config.Key) *Signer {
+	cfg := config.New()
+	cfg.Keyring = keyring
+	s, err := NewSigner(cfg)
+	require.Nil(t, err)
+	return s
+}
+
 func TestEncodeDecode(t *T) {
-	r := &AssignRequest{
+	s := testSigner(t, testKeyring)
+	r := &types.AssignRequest{
 		FileType:   "image",
 		MaxSizeStr: "1024",
 		TTL:        "2m",
 		//note: SigExpires is not transferred
+
+		MinWidthStr:    "100",
+		MaxHeightStr:   "200",
+		AspectRatioStr: "4:3",
+		MaxDurationStr: "60.5",
+		MinSizeStr:     "10",
+		SHA256:         "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
 	}
 	fid := base64.URLEncoding.EncodeToString([]byte("hello"))
 	f := fid + ".jpg"
-	ar, err := seaweed.NewResult("localhost:8080", f)
+	ar, err := seaweed.NewAssignResult("localhost:8080", f)
 	require.Nil(t, err)
 
-	str, err := encode(r, ar)
+	str, err := s.Sign(r, ar)
 	require.Nil(t, err)
 
-	r2, ar2, err := decode(str, f)
+	r2, ar2, err := s.decode(str, f)
 	require.Nil(t, err)
 
 	assert.EqualValues(t, r, r2)
@@ -33,22 +56,141 @@ func TestEncodeDecode(t *T) {
 }
 
 func TestExpires(t *T) {
+	s := testSigner(t, testKeyring)
 	fid := base64.URLEncoding.EncodeToString([]byte("hello"))
 	f := fid + ".jpg"
-	ar, err := seaweed.NewResult("localhost:8080", f)
+	ar, err := seaweed.NewAssignResult("localhost:8080", f)
 	require.Nil(t, err)
 
-	r := &AssignRequest{
+	r := &types.AssignRequest{
 		SigExpiresStr: "1",
 	}
-	str, err := encode(r, ar)
+	str, err := s.Sign(r, ar)
 	require.Nil(t, err)
 
-	_, _, err = decode(str, f)
+	_, _, err = s.decode(str, f)
 	require.Nil(t, err)
 
 	time.Sleep(2 * time.Second)
 
-	_, _, err = decode(str, f)
+	_, _, err = s.decode(str, f)
 	require.NotNil(t, err)
 }
+
+func TestKeyRotation(t *T) {
+	old := config.Key{ID: "old", Secret: "0123456789abcdef"}
+	s := testSigner(t, []config.Key{old})
+
+	r := &types.AssignRequest{}
+	fid := base64.URLEncoding.EncodeToString([]byte("hello"))
+	f := fid + ".jpg"
+	ar, err := seaweed.NewAssignResult("localhost:8080", f)
+	require.Nil(t, err)
+
+	str, err := s.Sign(r, ar)
+	require.Nil(t, err)
+	assert.True(t, strings.HasPrefix(str, "4$old$$"))
+
+	rotated := []config.Key{
+		{ID: "new", Secret: "a secret of any length"},
+		old,
+	}
+	s = testSigner(t, rotated)
+	_, _, err = s.decode(str, f)
+	assert.Nil(t, err)
+
+	str2, err := s.Sign(r, ar)
+	require.Nil(t, err)
+	assert.True(t, strings.HasPrefix(str2, "4$new$$"))
+
+	s = testSigner(t, rotated[:1])
+	_, _, err = s.decode(str, f)
+	assert.NotNil(t, err)
+	_, _, err = s.decode(str2, f)
+	assert.Nil(t, err)
+}
+
+// legacySig makes a version 1, 2 or 3 signature. Versions 1 and 2 used the
+// secret directly as the AES key
+func legacySig(t *T, version string, k config.Key, ar *seaweed.AssignResult) string {
+	req, err := compressRequest(&types.AssignRequest{})
+	require.Nil(t, err)
+	b, err := msgpack.Marshal(&signature{
+		Req:        req,
+		SeaweedURL: ar.Host(),
+		CRC:        crc32.ChecksumIEEE([]byte(ar.Filename())),
+	})
+	require.Nil(t, err)
+	g, err := gcm(k, version != "3")
+	require.Nil(t, err)
+	nonce := make([]byte, g.NonceSize())
+	parts := []string{version}
+	if version != "1" {
+		parts = append(parts, k.ID)
+	}
+	parts = append(parts,
+		encoder.EncodeToString(nonce),
+		encoder.EncodeToString(g.Seal(nil, nonce, b, nil)),
+	)
+	return strings.Join(parts, "$")
+}
+
+func TestLegacyVersions(t *T) {
+	old := config.Key{ID: "old", Secret: "0123456789abcdef"}
+	s := testSigner(t, []config.Key{
+		{ID: "new", Secret: "a secret of any length"},
+		old,
+	})
+
+	fid := base64.URLEncoding.EncodeToString([]byte("hello"))
+	f := fid + ".jpg"
+	ar, err := seaweed.NewAssignResult("localhost:8080", f)
+	require.Nil(t, err)
+
+	for _, v := range []string{"1", "2", "3"} {
+		_, _, err = s.decode(legacySig(t, v, old, ar), f)
+		assert.Nil(t, err, "version %s", v)
+	}
+}
+
+func TestAssociatedData(t *T) {
+	s := testSigner(t, testKeyring)
+	r := &types.AssignRequest{
+		Method: "put",
+		Owner:  "user1",
+	}
+	fid := base64.URLEncoding.EncodeToString([]byte("hello"))
+	f := fid + ".jpg"
+	ar, err := seaweed.NewAssignResult("localhost:8080", f)
+	require.Nil(t, err)
+
+	str, err := s.Sign(r, ar)
+	require.Nil(t, err)
+	assert.True(t, strings.HasPrefix(str, "4$0$PUT$"))
+
+	a := &types.Assignment{Signature: str, Filename: f, Owner: "user1"}
+	_, _, err = s.decodeSignature(a, "PUT")
+	assert.Nil(t, err)
+	_, _, err = s.decodeSignature(a, "")
+	assert.Nil(t, err)
+	_, _, err = s.decodeSignature(a, "POST")
+	assert.NotNil(t, err)
+
+	a.Owner = "user2"
+	_, _, err = s.decodeSignature(a, "PUT")
+	assert.NotNil(t, err)
+	a.Owner = ""
+	_, _, err = s.decodeSignature(a, "PUT")
+	assert.NotNil(t, err)
+
+	// the method in the signature can't be changed
+	a.Owner = "user1"
+	a.Signature = strings.Replace(str, "$PUT$", "$$", 1)
+	_, _, err = s.decodeSignature(a, "POST")
+	assert.NotNil(t, err)
+
+	// a different filename doesn't work even with the same extension stripped
+	f2 := base64.URLEncoding.EncodeToString([]byte("hellp")) + ".jpg"
+	_, _, err = s.decodeSignature(&types.Assignment{Signature: str, Filename: f2, Owner: "user1"}, "")
+	assert.NotNil(t, err)
+}
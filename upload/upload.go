@@ -1,29 +1,128 @@
 package upload
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/levenlabs/dank/backend"
+	"github.com/levenlabs/dank/config"
+	"github.com/levenlabs/dank/download"
 	dhttp "github.com/levenlabs/dank/http"
+	"github.com/levenlabs/dank/media"
+	"github.com/levenlabs/dank/meta"
+	"github.com/levenlabs/dank/metrics"
+	"github.com/levenlabs/dank/replay"
 	"github.com/levenlabs/dank/seaweed"
 	"github.com/levenlabs/dank/types"
 	"github.com/levenlabs/go-llog"
-	"image"
-	_ "image/gif"
-	_ "image/jpeg"
-	_ "image/png"
+	"hash"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"strings"
+	"time"
 )
 
+// MaxAssignCount is the most files that can be assigned with one AssignN call
+const MaxAssignCount = 1000
+
+// MaxNameLen and MaxOwnerLen are the longest name and owner, in bytes, that an
+// upload can have, since both are kept in the MetaStore
+const (
+	MaxNameLen  = 1024
+	MaxOwnerLen = 1024
+)
+
+// Uploader assigns files, checks uploads against the AssignRequest they were
+// signed with and stores them in a backend.Backend. Its Signer is used for the
+// signatures
+type Uploader struct {
+	*Signer
+
+	// MetaStore records information about every uploaded file. It's based on
+	// the MetaFile of the Config but can be replaced before any uploads
+	// happen
+	MetaStore meta.Store
+
+	// ReplayStore records which signatures have been used in order to make
+	// sure each signature is only used to upload once. It's based on the
+	// ReplayFile of the Config but can be replaced before any uploads happen
+	ReplayStore replay.Store
+
+	cfg        *config.Config
+	backend    backend.Backend
+	private    *download.Signer
+	chunkLocks *chunkLocks
+}
+
+// NewUploader returns an Uploader storing files in b. The MetaStore and
+// ReplayStore are opened from the MetaFile and ReplayFile of cfg, or kept in
+// memory if they're empty
+func NewUploader(cfg *config.Config, b backend.Backend) (*Uploader, error) {
+	s, err := NewSigner(cfg)
+	if err != nil {
+		return nil, err
+	}
+	u := &Uploader{
+		Signer:     s,
+		cfg:        cfg,
+		backend:    b,
+		private:    download.NewSigner(cfg),
+		chunkLocks: newChunkLocks(),
+	}
+	if u.MetaStore, err = newMetaStore(cfg.MetaFile); err != nil {
+		return nil, err
+	}
+	if u.ReplayStore, err = newReplayStore(cfg.ReplayFile); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
 // Assign takes an AssignRequest and returns an Assignment that can be used to
 // upload a file later
-func Assign(r *types.AssignRequest) (*types.Assignment, error) {
-	ar, err := seaweed.Assign(r.Replication, r.TTL)
+func (u *Uploader) Assign(r *types.AssignRequest) (*types.Assignment, error) {
+	ars, err := u.backend.Assign(r.Replication, r.TTL, 1)
+	if err != nil {
+		return nil, err
+	}
+	return u.assign(r, ars[0])
+}
+
+// AssignN is like Assign but returns r.Count() Assignments, each with its own
+// signature, using a single call to the backend
+func (u *Uploader) AssignN(r *types.AssignRequest) ([]*types.Assignment, error) {
+	count := r.Count()
+	if count > MaxAssignCount {
+		return nil, dhttp.NewError(http.StatusBadRequest, "count must be at most %d", MaxAssignCount)
+	}
+	ars, err := u.backend.Assign(r.Replication, r.TTL, count)
 	if err != nil {
 		return nil, err
 	}
+	as := make([]*types.Assignment, len(ars))
+	for i, ar := range ars {
+		if as[i], err = u.assign(r, ar); err != nil {
+			return nil, err
+		}
+	}
+	return as, nil
+}
+
+// assign signs the AssignResult from the backend and returns the Assignment
+func (u *Uploader) assign(r *types.AssignRequest, ar *seaweed.AssignResult) (*types.Assignment, error) {
+	var err error
+	if r.IsPrivate() {
+		if ar, err = u.private.MakePrivate(ar); err != nil {
+			return nil, err
+		}
+	}
 
-	sig, err := encode(r, ar)
+	sig, err := u.Sign(r, ar)
 	if err != nil {
 		return nil, err
 	}
@@ -35,6 +134,7 @@ func Assign(r *types.AssignRequest) (*types.Assignment, error) {
 		"maxSize":  r.MaxSize(),
 		"fileType": r.FileType,
 		"expires":  r.SigExpiresStr,
+		"private":  r.IsPrivate(),
 	})
 
 	a := &types.Assignment{
@@ -44,25 +144,87 @@ func Assign(r *types.AssignRequest) (*types.Assignment, error) {
 	return a, nil
 }
 
-// Upload takes an Assignment and a body and verifies that the body abides to
-// the original AssignRequest and then uploads the body to seaweed. blen should
-// indicate the length of the body. This can be http.Request's ContentLength.
-// ct should indicate the content-type of the body
-// urlParams should contain extra information you want to pass along in url params
-//
-// If a MaxSize was specified in the original AssignRequest, then the body
-// io.Reader is only read until the MaxSize
-func Upload(a *types.Assignment, body io.Reader, blen int64, ct string, urlParams map[string]string) error {
-	r, ar, err := decode(a.Signature, a.Filename)
+// Upload verifies that body abides by the AssignRequest the Assignment was
+// signed with, stores it in the backend and records it in the MetaStore. Each
+// signature can only be used once, so a 409 error is returned if it already
+// was used by an upload that didn't fail
+func (u *Uploader) Upload(a *types.Assignment, method string, body io.Reader, blen int64, ct, name string, urlParams map[string]string) (*Result, error) {
+	sig, ar, err := u.decodeSignature(a, method)
 	if err != nil {
 		llog.Info("error running decode in upload", llog.KV{
 			"error":    err,
 			"filename": a.Filename,
 			"sig":      a.Signature,
 		})
-		return dhttp.NewError(http.StatusBadRequest, "invalid signature or filename")
+		err = dhttp.NewError(http.StatusBadRequest, "invalid signature or filename").WithReason("signature")
+		metrics.Rejected(err)
+		return nil, err
+	}
+
+	if len(name) > MaxNameLen {
+		err = dhttp.NewError(http.StatusBadRequest, "name must be at most %d bytes", MaxNameLen)
+		metrics.Rejected(err)
+		return nil, err
+	}
+	if len(a.Owner) > MaxOwnerLen {
+		err = dhttp.NewError(http.StatusBadRequest, "owner must be at most %d bytes", MaxOwnerLen)
+		metrics.Rejected(err)
+		return nil, err
+	}
+
+	if err = u.claim(sig); err != nil {
+		metrics.Rejected(err)
+		return nil, err
+	}
+	r := sig.Req.decompress()
+	res, err := u.upload(r, ar, body, blen, ct, urlParams)
+	if err != nil {
+		u.release(sig)
+		metrics.Rejected(err)
+		return nil, err
 	}
+	metrics.Uploaded(res.Size)
 
+	rec := &meta.Record{
+		Filename:    a.Filename,
+		Name:        name,
+		ContentType: res.ContentType,
+		Size:        res.Size,
+		SHA256:      res.SHA256,
+		Owner:       a.Owner,
+		Uploaded:    time.Now().UTC(),
+	}
+	if d, err := backend.ParseTTL(r.TTL); err == nil {
+		e := rec.Uploaded.Add(d)
+		rec.Expires = &e
+	}
+	err = u.MetaStore.Put(rec)
+	if err != nil {
+		// the file was still uploaded so don't fail
+		llog.Error("error storing file metadata", llog.KV{
+			"filename": a.Filename,
+			"error":    err,
+		})
+	}
+	return res, nil
+}
+
+// Result holds information about a file that was uploaded
+type Result struct {
+	// ContentType is the content type the file was uploaded with
+	ContentType string
+
+	// Size is the number of bytes uploaded
+	Size int64
+
+	// SHA256 and MD5 are the hex encoded hashes of the uploaded file
+	SHA256 string
+	MD5    string
+}
+
+// upload validates the body against the AssignRequest and then uploads it to
+// the backend
+func (u *Uploader) upload(r *types.AssignRequest, ar *seaweed.AssignResult, body io.Reader, blen int64, ct string, urlParams map[string]string) (*Result, error) {
 	maxSize := r.MaxSize()
 	kv := llog.KV{
 		"filename": ar.Filename(),
@@ -80,49 +242,177 @@ func Upload(a *types.Assignment, body io.Reader, blen int64, ct string, urlParam
 	}
 
 	llog.Debug("checking filesize", kv)
-	if maxSize > 0 {
-		if blen > maxSize {
-			return dhttp.NewError(http.StatusRequestEntityTooLarge, "request is larger than %d bytes", maxSize)
-		}
-		body = io.LimitReader(body, maxSize)
+	if maxSize > 0 && blen > maxSize {
+		return nil, dhttp.NewError(http.StatusRequestEntityTooLarge, "request is larger than %d bytes", maxSize).WithReason("max_size")
 	}
+	// blen can't be trusted so the actual size is checked as the body is read
+	sr := &sizeReader{
+		r:     body,
+		min:   r.MinSize(),
+		max:   maxSize,
+		exact: r.ExactSize(),
+	}
+	hr := newHashReader(sr, r.SHA256, r.MD5)
+	body = hr
 
-	ok := true
-	switch r.FileType {
-	case "image":
-		var b []byte
-		b, err = ioutil.ReadAll(body)
-		if err != nil {
-			kv["error"] = err
-			llog.Info("error running ioutil.ReadAll", kv)
-			return dhttp.NewError(http.StatusBadRequest, "invalid body uploaded")
+	var validate func(*types.AssignRequest, io.ReaderAt, int64, llog.KV) error
+	switch {
+	case r.FileType == "image":
+		validate = validateImage
+	case r.HasDimensions():
+		// only the start of the image is needed to get its dimensions so
+		// keep what was read and send it along before the rest of the body
+		head := &bytes.Buffer{}
+		if err := validateImageConfig(r, io.TeeReader(body, head), kv); err != nil {
+			return nil, err
 		}
-		_, _, err := image.Decode(bytes.NewBuffer(b))
+		body = io.MultiReader(head, body)
+	case r.FileType == "audio" || r.FileType == "video" || r.HasDuration():
+		validate = validateMedia
+	}
+	if validate != nil {
+		f, size, err := spool(body)
 		if err != nil {
 			kv["error"] = err
-			if len(b) >= 3 {
-				kv["bytes"] = b[0:3]
+			llog.Info("error spooling body", kv)
+			if he, ok := err.(dhttp.HTTPError); ok {
+				return nil, he
 			}
-			llog.Info("error running image.Decode", kv)
-			ok = false
-		} else {
-			body = ioutil.NopCloser(bytes.NewBuffer(b))
+			return nil, dhttp.NewError(http.StatusBadRequest, "invalid body uploaded").WithReason("invalid_body")
+		}
+		defer removeSpool(f)
+		kv["spooledLen"] = size
+		if err = validate(r, f, size, kv); err != nil {
+			return nil, err
+		}
+		body = io.NewSectionReader(f, 0, size)
+	}
+
+	if allowed := r.AllowedTypesList(); len(allowed) > 0 {
+		br := bufio.NewReaderSize(body, media.SniffLen)
+		// Peek returns an error if the body is shorter but still returns
+		// what there is
+		sniff, _ := br.Peek(media.SniffLen)
+		body = br
+		detected := media.DetectContentType(sniff)
+		kv["detectedType"] = detected
+		if !media.MatchType(detected, allowed) {
+			llog.Info("uploaded file type not allowed", kv)
+			return nil, dhttp.NewError(http.StatusUnsupportedMediaType,
+				"uploaded file type %s is not allowed", media.NormalizeType(detected)).WithReason("type")
 		}
+		// never trust what the uploader said the type was
+		ct = detected
 	}
 
-	if ok {
-		llog.Info("uploading file to seaweed", kv)
-	} else {
-		return dhttp.NewError(http.StatusBadRequest,
-			"uploaded file could not be validated as %s", r.FileType)
+	llog.Info("uploading file to backend", kv)
+	if err := u.backend.Upload(ar, body, ct, urlParams); err != nil {
+		return nil, err
 	}
+	return &Result{
+		ContentType: ct,
+		Size:        sr.n,
+		SHA256:      hex.EncodeToString(hr.sha256.Sum(nil)),
+		MD5:         hex.EncodeToString(hr.md5.Sum(nil)),
+	}, nil
+}
 
-	return seaweed.Upload(ar, body, ct, urlParams)
+// sizeReader counts the bytes read from r. It returns an error once more than
+// max or exact bytes have been read, and an error instead of io.EOF if less
+// than min or exact bytes were read. A value of 0 means no requirement
+type sizeReader struct {
+	r               io.Reader
+	n               int64
+	min, max, exact int64
 }
 
-// Verify takes an assignment and validates the filename to the signature
-func Verify(a *types.Assignment) error {
-	_, _, err := decode(a.Signature, a.Filename)
+func (s *sizeReader) Read(b []byte) (int, error) {
+	n, err := s.r.Read(b)
+	s.n += int64(n)
+	if s.max > 0 && s.n > s.max {
+		return n, dhttp.NewError(http.StatusRequestEntityTooLarge, "request is larger than %d bytes", s.max).WithReason("max_size")
+	}
+	if s.exact > 0 && s.n > s.exact {
+		return n, dhttp.NewError(http.StatusBadRequest, "request is not %d bytes", s.exact).WithReason("exact_size")
+	}
+	if err == io.EOF {
+		if s.min > 0 && s.n < s.min {
+			return n, dhttp.NewError(http.StatusBadRequest, "request is smaller than %d bytes", s.min).WithReason("min_size")
+		}
+		if s.exact > 0 && s.n != s.exact {
+			return n, dhttp.NewError(http.StatusBadRequest, "request is not %d bytes", s.exact).WithReason("exact_size")
+		}
+	}
+	return n, err
+}
+
+// hashReader hashes everything read from r. If an expected hash was given then
+// it returns an error instead of io.EOF if the hash doesn't match
+type hashReader struct {
+	r                   io.Reader
+	sha256, md5         hash.Hash
+	wantSHA256, wantMD5 string
+}
+
+func newHashReader(r io.Reader, wantSHA256, wantMD5 string) *hashReader {
+	return &hashReader{
+		r:          r,
+		sha256:     sha256.New(),
+		md5:        md5.New(),
+		wantSHA256: strings.ToLower(wantSHA256),
+		wantMD5:    strings.ToLower(wantMD5),
+	}
+}
+
+func (h *hashReader) Read(b []byte) (int, error) {
+	n, err := h.r.Read(b)
+	h.sha256.Write(b[:n])
+	h.md5.Write(b[:n])
+	if err != io.EOF {
+		return n, err
+	}
+	if h.wantSHA256 != "" && hex.EncodeToString(h.sha256.Sum(nil)) != h.wantSHA256 {
+		return n, dhttp.NewError(http.StatusBadRequest, "request does not match sha256 %s", h.wantSHA256).WithReason("sha256")
+	}
+	if h.wantMD5 != "" && hex.EncodeToString(h.md5.Sum(nil)) != h.wantMD5 {
+		return n, dhttp.NewError(http.StatusBadRequest, "request does not match md5 %s", h.wantMD5).WithReason("md5")
+	}
+	return n, err
+}
+
+// spool copies the body to a temporary file so that validators which need the
+// whole file can read it without it being held in memory. The file must be
+// removed with removeSpool
+func spool(body io.Reader) (*os.File, int64, error) {
+	f, err := ioutil.TempFile("", "dank-upload-")
+	if err != nil {
+		return nil, 0, err
+	}
+	n, err := io.Copy(f, body)
+	if err != nil {
+		removeSpool(f)
+		return nil, 0, err
+	}
+	return f, n, nil
+}
+
+func removeSpool(f *os.File) {
+	f.Close()
+	if err := os.Remove(f.Name()); err != nil {
+		llog.Warn("error removing spooled upload", llog.KV{
+			"file":  f.Name(),
+			"error": err,
+		})
+	}
+}
+
+// Verify takes an assignment and validates the filename, and owner if the
+// signature has one, to the signature
+func (s *Signer) Verify(a *types.Assignment) error {
+	sig, _, err := s.decodeSignature(a, "")
+	if err == nil && !sig.canonical {
+		err = errors.New("non-canonical nonce")
+	}
 	if err != nil {
 		llog.Info("error running decode in upload", llog.KV{
 			"error":    err,
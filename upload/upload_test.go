@@ -4,25 +4,290 @@ import (
 	. "testing"
 
 	"encoding/base64"
+	"encoding/hex"
+	"github.com/levenlabs/dank/config"
+	dhttp "github.com/levenlabs/dank/http"
 	"github.com/levenlabs/dank/seaweed"
+	"github.com/levenlabs/dank/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
 )
 
+func testUploader(t *T) *Uploader {
+	cfg := config.New()
+	cfg.Keyring = testKeyring
+	u, err := NewUploader(cfg, nil)
+	require.Nil(t, err)
+	return u
+}
+
 func TestVerify(t *T) {
-	r := &AssignRequest{}
+	u := testUploader(t)
+	r := &types.AssignRequest{}
 	fid := base64.URLEncoding.EncodeToString([]byte("hello"))
 	f := fid + ".jpg"
-	ar, err := seaweed.NewResult("localhost:8080", f)
+	ar, err := seaweed.NewAssignResult("localhost:8080", f)
 	require.Nil(t, err)
 
-	str, err := encode(r, ar)
+	str, err := u.Sign(r, ar)
 	require.Nil(t, err)
 
-	a := &Assignment{
+	a := &types.Assignment{
 		Signature: str,
 		Filename:  f,
 	}
-	err = Verify(a)
+	err = u.Verify(a)
 	assert.Nil(t, err)
 }
+
+func TestUploadTooLong(t *T) {
+	u := testUploader(t)
+	fid := base64.URLEncoding.EncodeToString([]byte("hello"))
+	f := fid + ".jpg"
+	ar, err := seaweed.NewAssignResult("localhost:8080", f)
+	require.Nil(t, err)
+	long := strings.Repeat("x", MaxNameLen+1)
+
+	// the name and owner are kept in the MetaStore so they're limited
+	str, err := u.Sign(&types.AssignRequest{}, ar)
+	require.Nil(t, err)
+	a := &types.Assignment{Signature: str, Filename: f}
+	_, err = u.Upload(a, "POST", strings.NewReader("hi"), 2, "", long, nil)
+	require.NotNil(t, err)
+	assert.Equal(t, http.StatusBadRequest, err.(dhttp.HTTPError).Code())
+
+	str, err = u.Sign(&types.AssignRequest{Owner: long}, ar)
+	require.Nil(t, err)
+	a = &types.Assignment{Signature: str, Filename: f, Owner: long}
+	_, err = u.Upload(a, "POST", strings.NewReader("hi"), 2, "", "", nil)
+	require.NotNil(t, err)
+	assert.Equal(t, http.StatusBadRequest, err.(dhttp.HTTPError).Code())
+
+	// neither used up the signature
+	used, err := u.Used(a)
+	require.Nil(t, err)
+	assert.False(t, used)
+}
+
+func TestSignInvalidHash(t *T) {
+	u := testUploader(t)
+	fid := base64.URLEncoding.EncodeToString([]byte("hello"))
+	ar, err := seaweed.NewAssignResult("localhost:8080", fid+".jpg")
+	require.Nil(t, err)
+
+	// an invalid hash must not be dropped, since that would allow any file
+	for _, r := range []*types.AssignRequest{
+		{SHA256: "zz"},
+		{SHA256: "2cf24dba"},
+		{MD5: "5d41402abc4b2a76b9719d911017c59g"},
+	} {
+		_, err := u.Sign(r, ar)
+		require.NotNil(t, err)
+		he, ok := err.(dhttp.HTTPError)
+		require.True(t, ok)
+		assert.Equal(t, http.StatusBadRequest, he.Code())
+	}
+}
+
+func TestUsed(t *T) {
+	u := testUploader(t)
+	r := &types.AssignRequest{}
+	fid := base64.URLEncoding.EncodeToString([]byte("hello"))
+	f := fid + ".jpg"
+	ar, err := seaweed.NewAssignResult("localhost:8080", f)
+	require.Nil(t, err)
+
+	str, err := u.Sign(r, ar)
+	require.Nil(t, err)
+
+	a := &types.Assignment{
+		Signature: str,
+		Filename:  f,
+	}
+	used, err := u.Used(a)
+	require.Nil(t, err)
+	assert.False(t, used)
+
+	sig, _, err := u.decodeSignature(a, "")
+	require.Nil(t, err)
+	require.Nil(t, u.claim(sig))
+	used, err = u.Used(a)
+	require.Nil(t, err)
+	assert.True(t, used)
+	assert.NotNil(t, u.claim(sig))
+
+	// the same nonce written differently is the same signature
+	parts := strings.Split(str, "$")
+	parts[3] = parts[3][:4] + "\n" + parts[3][4:]
+	a2 := &types.Assignment{Signature: strings.Join(parts, "$"), Filename: f}
+	used, err = u.Used(a2)
+	require.Nil(t, err)
+	assert.True(t, used)
+	sig2, _, err := u.decodeSignature(a2, "")
+	require.Nil(t, err)
+	assert.Equal(t, sig.nonce, sig2.nonce)
+	assert.NotNil(t, u.claim(sig2))
+	assert.NotNil(t, u.Verify(a2))
+
+	u.release(sig)
+	used, err = u.Used(a)
+	require.Nil(t, err)
+	assert.False(t, used)
+	// and it can't be used to claim it either
+	assert.NotNil(t, u.claim(sig2))
+	require.Nil(t, u.claim(sig))
+}
+
+func TestCheckDimensions(t *T) {
+	r := &types.AssignRequest{
+		MinWidthStr:  "100",
+		MaxWidthStr:  "1000",
+		MinHeightStr: "50",
+		MaxHeightStr: "500",
+	}
+	assert.Nil(t, checkDimensions(r, 100, 50))
+	assert.Nil(t, checkDimensions(r, 1000, 500))
+	assert.NotNil(t, checkDimensions(r, 99, 50))
+	assert.NotNil(t, checkDimensions(r, 1001, 50))
+	assert.NotNil(t, checkDimensions(r, 100, 49))
+	assert.NotNil(t, checkDimensions(r, 100, 501))
+
+	r = &types.AssignRequest{AspectRatioStr: "16:9"}
+	assert.Nil(t, checkDimensions(r, 1920, 1080))
+	assert.Nil(t, checkDimensions(r, 1921, 1080))
+	assert.NotNil(t, checkDimensions(r, 1080, 1920))
+
+	r.AspectRatioStr = "1"
+	assert.Nil(t, checkDimensions(r, 100, 100))
+	assert.NotNil(t, checkDimensions(r, 100, 90))
+	r.AspectRatioToleranceStr = "0.2"
+	assert.Nil(t, checkDimensions(r, 100, 90))
+}
+
+func TestCheckDuration(t *T) {
+	r := &types.AssignRequest{
+		MinDurationStr: "1.5",
+		MaxDurationStr: "60",
+	}
+	assert.Nil(t, checkDuration(r, 1500*time.Millisecond))
+	assert.Nil(t, checkDuration(r, time.Minute))
+	assert.NotNil(t, checkDuration(r, time.Second))
+	assert.NotNil(t, checkDuration(r, time.Minute+time.Millisecond))
+	// an unknown duration can't be under the max
+	assert.NotNil(t, checkDuration(&types.AssignRequest{MaxDurationStr: "60"}, 0))
+}
+
+func TestSizeReader(t *T) {
+	read := func(body string, min, max, exact int64) error {
+		_, err := ioutil.ReadAll(&sizeReader{
+			r:     strings.NewReader(body),
+			min:   min,
+			max:   max,
+			exact: exact,
+		})
+		return err
+	}
+	assert.Nil(t, read("hello", 0, 0, 0))
+	assert.Nil(t, read("hello", 5, 5, 5))
+	assert.NotNil(t, read("hello", 6, 0, 0))
+	assert.NotNil(t, read("hello", 0, 4, 0))
+	assert.NotNil(t, read("hello", 0, 0, 4))
+	assert.NotNil(t, read("hello", 0, 0, 6))
+	assert.NotNil(t, read("", 1, 0, 0))
+}
+
+func TestHashReader(t *T) {
+	sha := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	md := "5d41402abc4b2a76b9719d911017c592"
+	read := func(body, wantSHA256, wantMD5 string) (*hashReader, error) {
+		h := newHashReader(strings.NewReader(body), wantSHA256, wantMD5)
+		_, err := ioutil.ReadAll(h)
+		return h, err
+	}
+
+	h, err := read("hello", "", "")
+	require.Nil(t, err)
+	assert.Equal(t, sha, hex.EncodeToString(h.sha256.Sum(nil)))
+	assert.Equal(t, md, hex.EncodeToString(h.md5.Sum(nil)))
+
+	_, err = read("hello", sha, md)
+	assert.Nil(t, err)
+	_, err = read("hello", strings.ToUpper(sha), "")
+	assert.Nil(t, err)
+	_, err = read("hello!", sha, "")
+	assert.NotNil(t, err)
+	_, err = read("hello!", "", md)
+	assert.NotNil(t, err)
+}
+
+func TestChunk(t *T) {
+	dir, err := ioutil.TempDir("", "dank-chunks")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	u := testUploader(t)
+	u.cfg.ChunkDir = dir
+
+	r := &types.AssignRequest{MaxSizeStr: "10"}
+	fid := base64.URLEncoding.EncodeToString([]byte("hello"))
+	f := fid + ".jpg"
+	ar, err := seaweed.NewAssignResult("localhost:8080", f)
+	require.Nil(t, err)
+	str, err := u.Sign(r, ar)
+	require.Nil(t, err)
+	a := &types.Assignment{
+		Signature: str,
+		Filename:  f,
+	}
+
+	offset, err := u.Offset(a)
+	require.Nil(t, err)
+	assert.Equal(t, int64(0), offset)
+
+	offset, err = u.Chunk(a, "POST", 0, strings.NewReader("hello"))
+	require.Nil(t, err)
+	assert.Equal(t, int64(5), offset)
+
+	// can't skip ahead
+	offset, err = u.Chunk(a, "POST", 6, strings.NewReader("world"))
+	assert.NotNil(t, err)
+	assert.Equal(t, int64(5), offset)
+
+	// resending part of a chunk replaces it
+	offset, err = u.Chunk(a, "POST", 3, strings.NewReader("lo wor"))
+	require.Nil(t, err)
+	assert.Equal(t, int64(9), offset)
+
+	// going over the max size keeps what was there
+	offset, err = u.Chunk(a, "POST", 9, strings.NewReader("ld"))
+	assert.NotNil(t, err)
+	assert.Equal(t, int64(9), offset)
+
+	offset, err = u.Offset(a)
+	require.Nil(t, err)
+	assert.Equal(t, int64(9), offset)
+	sig, _, err := u.decodeSignature(a, "")
+	require.Nil(t, err)
+	b, err := ioutil.ReadFile(u.chunkPath(sig))
+	require.Nil(t, err)
+	assert.Equal(t, "hello wor", string(b))
+
+	// the nonce written differently can't start another chunk file
+	parts := strings.Split(str, "$")
+	parts[3] = parts[3][:4] + "\n" + parts[3][4:]
+	a2 := &types.Assignment{Signature: strings.Join(parts, "$"), Filename: f}
+	_, err = u.Chunk(a2, "POST", 0, strings.NewReader("hello"))
+	assert.NotNil(t, err)
+	sig2, _, err := u.decodeSignature(a2, "")
+	require.Nil(t, err)
+	assert.Equal(t, u.chunkPath(sig), u.chunkPath(sig2))
+
+	// nothing can be sent once the signature is used
+	require.Nil(t, u.claim(sig))
+	_, err = u.Chunk(a, "POST", 9, strings.NewReader("d"))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,116 @@
+package upload
+
+import (
+	dhttp "github.com/levenlabs/dank/http"
+	"github.com/levenlabs/dank/media"
+	"github.com/levenlabs/dank/types"
+	"github.com/levenlabs/go-llog"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+	"net/http"
+	"time"
+)
+
+// validateImageConfig checks that rd is an image that meets the dimension
+// requirements in the AssignRequest. Only the start of the image is read
+func validateImageConfig(r *types.AssignRequest, rd io.Reader, kv llog.KV) error {
+	cfg, _, err := image.DecodeConfig(rd)
+	if he, ok := err.(dhttp.HTTPError); ok {
+		// the body didn't meet the size requirements
+		return he
+	} else if err != nil {
+		kv["error"] = err
+		llog.Info("error running image.DecodeConfig", kv)
+		return dhttp.NewError(http.StatusBadRequest,
+			"uploaded file could not be validated as image").WithReason("image")
+	}
+	kv["width"] = cfg.Width
+	kv["height"] = cfg.Height
+	if err = checkDimensions(r, cfg.Width, cfg.Height); err != nil {
+		kv["error"] = err
+		llog.Info("image dimensions invalid", kv)
+		return err
+	}
+	return nil
+}
+
+// validateImage checks that the whole image can be decoded and meets the
+// dimension requirements in the AssignRequest
+func validateImage(r *types.AssignRequest, ra io.ReaderAt, size int64, kv llog.KV) error {
+	// check the dimensions before decoding the whole image so we don't
+	// decode huge images only to reject them
+	if err := validateImageConfig(r, io.NewSectionReader(ra, 0, size), kv); err != nil {
+		return err
+	}
+	if _, _, err := image.Decode(io.NewSectionReader(ra, 0, size)); err != nil {
+		kv["error"] = err
+		llog.Info("error running image.Decode", kv)
+		return dhttp.NewError(http.StatusBadRequest,
+			"uploaded file could not be validated as image").WithReason("image")
+	}
+	return nil
+}
+
+// checkDimensions returns an error if the width and height of an image don't
+// meet the requirements in the AssignRequest
+func checkDimensions(r *types.AssignRequest, w, h int) error {
+	if min := r.MinWidth(); min > 0 && w < min {
+		return dhttp.NewError(http.StatusBadRequest, "image width is less than %d", min).WithReason("dimensions")
+	}
+	if max := r.MaxWidth(); max > 0 && w > max {
+		return dhttp.NewError(http.StatusBadRequest, "image width is greater than %d", max).WithReason("dimensions")
+	}
+	if min := r.MinHeight(); min > 0 && h < min {
+		return dhttp.NewError(http.StatusBadRequest, "image height is less than %d", min).WithReason("dimensions")
+	}
+	if max := r.MaxHeight(); max > 0 && h > max {
+		return dhttp.NewError(http.StatusBadRequest, "image height is greater than %d", max).WithReason("dimensions")
+	}
+	if ar := r.AspectRatio(); ar > 0 {
+		if h == 0 || math.Abs(float64(w)/float64(h)-ar) > ar*r.AspectRatioTolerance() {
+			return dhttp.NewError(http.StatusBadRequest, "image aspect ratio is not %s", r.AspectRatioStr).WithReason("dimensions")
+		}
+	}
+	return nil
+}
+
+// validateMedia checks that the file is audio or video, depending on the
+// FileType, and that it meets the duration requirements in the AssignRequest
+func validateMedia(r *types.AssignRequest, ra io.ReaderAt, size int64, kv llog.KV) error {
+	t := r.FileType
+	if t == "" {
+		t = "audio or video"
+	}
+	info, err := media.Probe(ra, size)
+	if err != nil {
+		kv["error"] = err
+		llog.Info("error running media.Probe", kv)
+		return dhttp.NewError(http.StatusBadRequest,
+			"uploaded file could not be validated as %s", t).WithReason("media")
+	}
+	kv["format"] = info.Format
+	kv["mediaType"] = info.Type
+	kv["duration"] = info.Duration
+	if r.FileType != "" && info.Type != r.FileType {
+		llog.Info("uploaded media was the wrong type", kv)
+		return dhttp.NewError(http.StatusBadRequest,
+			"uploaded file could not be validated as %s", t).WithReason("media")
+	}
+	return checkDuration(r, info.Duration)
+}
+
+// checkDuration returns an error if the duration of audio or video doesn't
+// meet the requirements in the AssignRequest
+func checkDuration(r *types.AssignRequest, d time.Duration) error {
+	if min := r.MinDuration(); min > 0 && d < min {
+		return dhttp.NewError(http.StatusBadRequest, "duration is less than %s seconds", r.MinDurationStr).WithReason("duration")
+	}
+	if max := r.MaxDuration(); max > 0 && (d == 0 || d > max) {
+		return dhttp.NewError(http.StatusBadRequest, "duration is greater than %s seconds", r.MaxDurationStr).WithReason("duration")
+	}
+	return nil
+}